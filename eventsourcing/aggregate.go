@@ -4,3 +4,11 @@ package eventsourcing
 type Aggregate interface {
 	On(event Event) error
 }
+
+// CloneableAggregate is an Aggregate that copies itself. The cache and asynchronous observers otherwise copy
+// aggregates through encoding/json, which leaves out unexported fields; an aggregate whose state does not
+// survive a JSON round trip implements Clone to return a deep copy of itself instead.
+type CloneableAggregate interface {
+	Aggregate
+	Clone() Aggregate
+}
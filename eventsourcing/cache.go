@@ -0,0 +1,180 @@
+package eventsourcing
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+// WithCache makes the Repository keep the state of recently loaded aggregates in memory, so a Load replays
+// only the events saved since. The cache holds at most maxEntries aggregates and maxBytes bytes of state,
+// dropping the least recently used first; 0 lifts a limit.
+// State is stored with encoding/json, like snapshots, so a cached aggregate never shares memory with the ones
+// Load returns. Aggregates must therefore survive a JSON round trip, unless they implement CloneableAggregate,
+// in which case the cache keeps a clone instead; their size is still that of their JSON encoding.
+func WithCache(maxEntries, maxBytes int) Option {
+	return func(r *Repository) {
+		r.cache = &aggregateCache{
+			maxEntries: maxEntries,
+			maxBytes:   maxBytes,
+			lru:        list.New(),
+			entries:    map[string]*list.Element{},
+		}
+	}
+}
+
+// Invalidate drops the cached state of an aggregate, for instance after its stream was deleted or rewritten
+// behind the Repository's back. Without a cache it does nothing.
+func (r *Repository) Invalidate(aggregateID string) {
+	r.cache.invalidate(aggregateID)
+}
+
+// cached returns the cached state of an aggregate; the state has no aggregate when none is cached.
+// Either way, it carries the cache generation to remember the aggregate in once loaded.
+func (r *Repository) cached(aggregateID string) *loaded {
+	entry, generation := r.cache.get(aggregateID)
+	state := &loaded{generation: generation}
+	if entry == nil {
+		return state
+	}
+
+	if entry.aggregate != nil {
+		cloneable, ok := entry.aggregate.(CloneableAggregate)
+		if !ok {
+			r.cache.invalidate(aggregateID)
+			return state
+		}
+		state.aggregate = cloneable.Clone()
+	} else {
+		aggregate := r.newPrototype()
+		if err := json.Unmarshal(entry.data, aggregate); err != nil {
+			r.cache.invalidate(aggregateID)
+			return state
+		}
+		state.aggregate = aggregate
+	}
+	state.version = entry.version
+	state.snapshot = entry.snapshot
+	return state
+}
+
+// remember caches the state of an aggregate, unless the cache was invalidated since the state was loaded
+func (r *Repository) remember(aggregateID string, state *loaded) {
+	if r.cache == nil {
+		return
+	}
+	entry := &cacheEntry{aggregateID: aggregateID, version: state.version}
+	if cloneable, ok := state.aggregate.(CloneableAggregate); ok {
+		entry.aggregate = cloneable.Clone()
+		if r.cache.maxBytes > 0 {
+			data, err := json.Marshal(state.aggregate)
+			if err != nil {
+				return
+			}
+			entry.size = len(data)
+		}
+	} else {
+		data, err := json.Marshal(state.aggregate)
+		if err != nil {
+			return
+		}
+		entry.data = data
+		entry.size = len(data)
+	}
+	if state.snapshot != nil {
+		// The snapshot policy only needs to know when the snapshot was taken and at which version
+		snapshot := *state.snapshot
+		snapshot.Data = nil
+		entry.snapshot = &snapshot
+	}
+	r.cache.put(entry, state.generation)
+}
+
+// aggregateCache is a least recently used cache of aggregate states, bounded by entries and bytes
+type aggregateCache struct {
+	mux        sync.Mutex
+	maxEntries int
+	maxBytes   int
+	size       int
+	generation uint64
+	lru        *list.List
+	entries    map[string]*list.Element
+}
+
+// cacheEntry is the state of an aggregate at a version, along with the newest snapshot known at the time.
+// The state is either encoded in data or, for a CloneableAggregate, a clone of the aggregate.
+type cacheEntry struct {
+	aggregateID string
+	data        []byte
+	aggregate   Aggregate
+	size        int
+	version     int
+	snapshot    *eventstore.Snapshot
+}
+
+// get returns the entry of an aggregate, if any, and the generation of the cache. Invalidating any aggregate
+// starts a new generation, so a state loaded before an invalidation is never put in the cache after it.
+func (c *aggregateCache) get(aggregateID string) (*cacheEntry, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	element, ok := c.entries[aggregateID]
+	if !ok {
+		return nil, c.generation
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry), c.generation
+}
+
+// put caches an entry loaded during generation. It keeps the cached entry when that one is newer.
+func (c *aggregateCache) put(entry *cacheEntry, generation uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		c.remove(entry.aggregateID)
+		return
+	}
+	if element, ok := c.entries[entry.aggregateID]; ok {
+		if element.Value.(*cacheEntry).version > entry.version {
+			return
+		}
+		c.remove(entry.aggregateID)
+	}
+
+	c.entries[entry.aggregateID] = c.lru.PushFront(entry)
+	c.size += entry.size
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.lru.Back().Value.(*cacheEntry).aggregateID)
+	}
+}
+
+// invalidate drops the entry of an aggregate and starts a new generation
+func (c *aggregateCache) invalidate(aggregateID string) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.generation++
+	c.remove(aggregateID)
+}
+
+func (c *aggregateCache) remove(aggregateID string) {
+	element, ok := c.entries[aggregateID]
+	if !ok {
+		return
+	}
+	c.lru.Remove(element)
+	delete(c.entries, aggregateID)
+	c.size -= element.Value.(*cacheEntry).size
+}
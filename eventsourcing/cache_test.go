@@ -0,0 +1,239 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// snapshotCounter is a SnapshotStore that counts the snapshots saved
+type snapshotCounter struct {
+	eventstore.SnapshotStore
+	saved int
+}
+
+func (s *snapshotCounter) SaveSnapshot(ctx context.Context, snapshot eventstore.Snapshot) error {
+	s.saved++
+	return s.SnapshotStore.SaveSnapshot(ctx, snapshot)
+}
+
+// countingTodo is a MyTodo that counts the events it was built from in an unexported field, which a JSON round
+// trip would lose, so it clones itself
+type countingTodo struct {
+	MyTodo
+	events int
+}
+
+func (t *countingTodo) On(e Event) error {
+	t.events++
+	return t.MyTodo.On(e)
+}
+
+func (t *countingTodo) Clone() Aggregate {
+	clone := *t
+	return &clone
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+
+	t.Run("Apply replays only the events saved since the cached state", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithCache(10, 0))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		agg, err := repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		assert.Equal(ct, []int{0, 0, 2, 2, 3, 3}, store.fromVersions)
+		todo := agg.(*MyTodo)
+		assert.Equal(ct, "Do this", todo.Desc)
+		assert.False(ct, todo.Done)
+		assert.Equal(ct, 3, todo.Version)
+	})
+
+	t.Run("changing a loaded aggregate does not change the cache", func(ct *testing.T) {
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil, WithCache(10, 0))
+
+		var id = uuid.NewV4().String()
+		agg, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		agg.(*MyTodo).Desc = "Changed"
+
+		agg, err = repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", agg.(*MyTodo).Desc)
+	})
+
+	t.Run("a CloneableAggregate keeps its unexported state", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		repo := NewRepository(reflect.TypeOf(countingTodo{}), store, serializer, nil, WithCache(10, 0))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		agg, err := repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		agg.(*countingTodo).events = 100
+
+		store.fromVersions = nil
+		agg, err = repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		todo := agg.(*countingTodo)
+		assert.Equal(ct, []int{3}, store.fromVersions)
+		assert.Equal(ct, 2, todo.events)
+		assert.True(ct, todo.Done)
+	})
+
+	t.Run("Invalidate makes the next Load replay every event", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithCache(10, 0))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		store.fromVersions = nil
+		_, _ = repo.Load(ctx, id)
+		repo.Invalidate(id)
+		_, _ = repo.Load(ctx, id)
+		assert.Equal(ct, []int{2, 0}, store.fromVersions)
+	})
+
+	t.Run("the least recently used aggregate goes first", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithCache(2, 0))
+
+		ids := []string{uuid.NewV4().String(), uuid.NewV4().String(), uuid.NewV4().String()}
+		for _, id := range ids {
+			_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+			assert.NoError(ct, err)
+		}
+
+		store.fromVersions = nil
+		for _, id := range ids {
+			_, err := repo.Load(ctx, id)
+			assert.NoError(ct, err)
+		}
+		// Loading the first aggregate again evicts the second, which then evicts the third
+		assert.Equal(ct, []int{0, 0, 0}, store.fromVersions)
+
+		store.fromVersions = nil
+		_, _ = repo.Load(ctx, ids[1])
+		_, _ = repo.Load(ctx, ids[2])
+		assert.Equal(ct, []int{2, 2}, store.fromVersions)
+	})
+
+	t.Run("state larger than the cache is not cached", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithCache(0, 16))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		store.fromVersions = nil
+		_, _ = repo.Load(ctx, id)
+		assert.Equal(ct, []int{0}, store.fromVersions)
+	})
+
+	t.Run("the cache keeps track of the newest snapshot", func(ct *testing.T) {
+		snapshots := &snapshotCounter{SnapshotStore: eventstore.GetLocalSnapshotStore()}
+		repo := NewRepository(
+			reflect.TypeOf(MyTodo{}),
+			eventstore.GetLocalStore(),
+			serializer,
+			nil,
+			WithCache(10, 0),
+			WithSnapshots(snapshots, SnapshotEvery(2)),
+		)
+
+		var id = uuid.NewV4().String()
+		commands := []Command{
+			&CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"},
+			&MarkDone{CommandModel{id}},
+			&MarkUndone{CommandModel{id}},
+			&MarkDone{CommandModel{id}},
+		}
+		for _, command := range commands {
+			_, err := repo.Apply(ctx, command)
+			assert.NoError(ct, err)
+		}
+		assert.Equal(ct, 2, snapshots.saved)
+	})
+}
+
+func TestCacheConcurrency(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	store := eventstore.GetLocalStore()
+	repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithCache(1, 0))
+
+	ids := []string{uuid.NewV4().String(), uuid.NewV4().String()}
+	for _, id := range ids {
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(t, err)
+	}
+
+	// Writers toggle the aggregates, retrying on conflicts, while the cache evicts and invalidates under them
+	const writers, toggles = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < toggles; {
+				agg, err := repo.Load(ctx, id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var command Command = &MarkDone{CommandModel{id}}
+				if agg.(*MyTodo).Done {
+					command = &MarkUndone{CommandModel{id}}
+				}
+
+				_, err = repo.Apply(ctx, command)
+				var conflict *eventstore.ErrConcurrencyConflict
+				switch {
+				case err == nil:
+					i++
+				case errors.As(err, &conflict):
+				case err.Error() == "MyTodo "+id+" is already done" || err.Error() == "MyTodo "+id+" is already undone":
+				default:
+					t.Error(err)
+					return
+				}
+			}
+		}(ids[w%len(ids)])
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writers*toggles; i++ {
+			repo.Invalidate(ids[i%len(ids)])
+		}
+	}()
+	wg.Wait()
+
+	uncached := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil)
+	for _, id := range ids {
+		cached, err := repo.Load(ctx, id)
+		assert.NoError(t, err)
+		replayed, err := uncached.Load(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, replayed, cached)
+		assert.Equal(t, 1+writers/len(ids)*toggles, cached.(*MyTodo).Version)
+	}
+}
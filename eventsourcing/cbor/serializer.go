@@ -0,0 +1,113 @@
+// Package cbor provides a schemaless binary Serializer that encodes events with CBOR (RFC 8949).
+// Its records hold the same envelope as the records of eventsourcing.JSONSerializer, the event type name
+// and the event data, behind the FormatByte that eventsourcing.CompositeSerializer reads them by.
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cannahum/eventsourcing-lite/eventsourcing"
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// FormatByte is the first byte of the records the Serializer writes
+const FormatByte byte = 0x01
+
+type cborEvent struct {
+	Type string          `cbor:"t"`
+	Data cbor.RawMessage `cbor:"d"`
+}
+
+// encMode encodes maps in canonical order and times with nanoseconds, as JSON does
+var encMode = func() cbor.EncMode {
+	opts := cbor.CanonicalEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+	mode, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// Serializer converts between Events and Records encoded with CBOR
+type Serializer struct {
+	eventTypes map[string]reflect.Type
+}
+
+// Bind registers the specified events with the serializer; may be called more than once
+func (s *Serializer) Bind(events ...eventsourcing.Event) {
+	for _, event := range events {
+		eventType, t := event.EventType()
+		s.eventTypes[t] = eventType
+	}
+}
+
+// Format implements the eventsourcing.FormatSerializer interface
+func (s *Serializer) Format() byte {
+	return FormatByte
+}
+
+// MarshalEvent converts an event into its persistent type, Record
+func (s *Serializer) MarshalEvent(ev eventsourcing.Event) (eventstore.Record, error) {
+	_, eventType := ev.EventType()
+
+	data, err := encMode.Marshal(ev)
+	if err != nil {
+		return eventstore.Record{}, err
+	}
+
+	envelope, err := encMode.Marshal(cborEvent{
+		Type: eventType,
+		Data: data,
+	})
+	if err != nil {
+		return eventstore.Record{}, fmt.Errorf("unable to encode event")
+	}
+
+	return eventstore.Record{
+		Version:    ev.EventVersion(),
+		Data:       append([]byte{FormatByte}, envelope...),
+		EventType:  eventType,
+		OccurredAt: ev.EventAt(),
+	}, nil
+}
+
+// UnmarshalEvent converts the persistent type, Record, into an Event instance
+func (s *Serializer) UnmarshalEvent(record eventstore.Record) (eventsourcing.Event, error) {
+	if len(record.Data) == 0 || record.Data[0] != FormatByte {
+		return nil, errors.New("record is not in the CBOR format")
+	}
+
+	wrapper := cborEvent{}
+	err := cbor.Unmarshal(record.Data[1:], &wrapper)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal event")
+	}
+
+	t, ok := s.eventTypes[wrapper.Type]
+	if !ok {
+		return nil, fmt.Errorf("unbound event type, %v", wrapper.Type)
+	}
+
+	v := reflect.New(t).Interface()
+	err = cbor.Unmarshal(wrapper.Data, v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal event data into %#v", v)
+	}
+
+	return v.(eventsourcing.Event), nil
+}
+
+// NewSerializer constructs a new Serializer and populates it with the specified events.
+// Bind may be subsequently called to add more events.
+func NewSerializer(events ...eventsourcing.Event) *Serializer {
+	serializer := &Serializer{
+		eventTypes: map[string]reflect.Type{},
+	}
+	serializer.Bind(events...)
+
+	return serializer
+}
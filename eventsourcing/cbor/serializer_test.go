@@ -0,0 +1,140 @@
+package cbor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventsourcing"
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"github.com/fxamacker/cbor/v2"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type todoCreated struct {
+	eventsourcing.Model
+	Desc string
+}
+
+func (t todoCreated) EventType() (reflect.Type, string) {
+	return reflect.TypeOf(t), "TodoCreated"
+}
+
+type todoDone struct {
+	eventsourcing.Model
+}
+
+func (t todoDone) EventType() (reflect.Type, string) {
+	return reflect.TypeOf(t), "TodoDone"
+}
+
+// todo is an aggregate built from the events above
+type todo struct {
+	Desc    string
+	Done    bool
+	Version int
+}
+
+func (t *todo) On(e eventsourcing.Event) error {
+	switch ev := e.(type) {
+	case *todoCreated:
+		t.Desc = ev.Desc
+	case *todoDone:
+		t.Done = true
+	default:
+		return fmt.Errorf("unable to handle event %v", ev)
+	}
+	t.Version = e.EventVersion()
+	return nil
+}
+
+func TestSerializer(t *testing.T) {
+	serializer := NewSerializer(todoCreated{}, todoDone{})
+	created := todoCreated{
+		Model: eventsourcing.Model{ID: uuid.NewV4().String(), Version: 1, At: time.Date(2022, 7, 1, 0, 0, 0, 1, time.UTC)},
+		Desc:  "Do this",
+	}
+
+	t.Run("test MarshalEvent and UnmarshalEvent", func(ct *testing.T) {
+		record, err := serializer.MarshalEvent(created)
+		assert.NoError(ct, err)
+		assert.Equal(ct, FormatByte, record.Data[0])
+		assert.Equal(ct, "TodoCreated", record.EventType)
+		assert.Equal(ct, 1, record.Version)
+
+		event, err := serializer.UnmarshalEvent(record)
+		assert.NoError(ct, err)
+		assert.Equal(ct, &created, event)
+	})
+
+	t.Run("test records hold a {t, d} envelope", func(ct *testing.T) {
+		record, _ := serializer.MarshalEvent(created)
+		envelope := map[string]cbor.RawMessage{}
+		assert.NoError(ct, cbor.Unmarshal(record.Data[1:], &envelope))
+
+		var eventType string
+		assert.NoError(ct, cbor.Unmarshal(envelope["t"], &eventType))
+		assert.Equal(ct, "TodoCreated", eventType)
+		var data struct{ Desc string }
+		assert.NoError(ct, cbor.Unmarshal(envelope["d"], &data))
+		assert.Equal(ct, "Do this", data.Desc)
+	})
+
+	t.Run("test UnmarshalEvent of an unbound event type (error)", func(ct *testing.T) {
+		record, _ := serializer.MarshalEvent(created)
+		_, err := NewSerializer(todoDone{}).UnmarshalEvent(record)
+		assert.Error(ct, err)
+	})
+
+	t.Run("test UnmarshalEvent of a record in another format (error)", func(ct *testing.T) {
+		record, _ := eventsourcing.NewJSONSerializer(todoCreated{}).MarshalEvent(created)
+		_, err := serializer.UnmarshalEvent(record)
+		assert.Error(ct, err)
+	})
+
+	t.Run("test UnmarshalEvent of malformed data (error)", func(ct *testing.T) {
+		_, err := serializer.UnmarshalEvent(eventstore.Record{Version: 1, Data: []byte{FormatByte, 0xff}})
+		assert.Error(ct, err)
+	})
+}
+
+func TestCompositeSerializer(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.GetLocalStore()
+	jsonSerializer := eventsourcing.NewJSONSerializer(todoCreated{}, todoDone{})
+	cborSerializer := NewSerializer(todoCreated{}, todoDone{})
+
+	// The aggregate was started in JSON, and the store moves on to CBOR
+	id := uuid.NewV4().String()
+	before := eventsourcing.NewRepository(reflect.TypeOf(todo{}), store, jsonSerializer, nil)
+	err := before.Save(ctx, todoCreated{Model: eventsourcing.Model{ID: id, Version: 1, At: time.Now()}, Desc: "Do this"})
+	assert.NoError(t, err)
+
+	composite := eventsourcing.NewCompositeSerializer(cborSerializer, jsonSerializer)
+	after := eventsourcing.NewRepository(reflect.TypeOf(todo{}), store, composite, nil)
+	err = after.Save(ctx, todoDone{Model: eventsourcing.Model{ID: id, Version: 2, At: time.Now()}})
+	assert.NoError(t, err)
+
+	t.Run("test records are written with the encoder", func(ct *testing.T) {
+		history, err := store.Load(ctx, id, 0, 0)
+		assert.NoError(ct, err)
+		assert.Equal(ct, eventsourcing.FormatJSON, history[0].Data[0])
+		assert.Equal(ct, FormatByte, history[1].Data[0])
+	})
+
+	t.Run("test records in either format are read", func(ct *testing.T) {
+		agg, err := after.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, &todo{Desc: "Do this", Done: true, Version: 2}, agg)
+	})
+
+	t.Run("test records in a format without a decoder (error)", func(ct *testing.T) {
+		_, err := before.Load(ctx, id)
+		assert.Error(ct, err)
+		_, err = eventsourcing.NewCompositeSerializer(jsonSerializer).UnmarshalEvent(eventstore.Record{Data: []byte{FormatByte}})
+		assert.Error(ct, err)
+	})
+}
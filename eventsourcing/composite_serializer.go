@@ -0,0 +1,68 @@
+package eventsourcing
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+// FormatJSON is the first byte of the records JSONSerializer writes, the opening brace of its envelope.
+// Unlike other formats it has no byte of its own, so a CompositeSerializer reads any record that starts with a
+// brace as a JSONSerializer record; records holding other JSON must not be mixed in.
+const FormatJSON byte = '{'
+
+// FormatSerializer is a Serializer whose records all start with the same byte, which tells its format apart
+// from the formats of other serializers
+type FormatSerializer interface {
+	Serializer
+
+	// Format returns the first byte of the records the serializer writes
+	Format() byte
+}
+
+// Format implements the FormatSerializer interface
+func (j *JSONSerializer) Format() byte {
+	return FormatJSON
+}
+
+// CompositeSerializer writes records with one serializer and reads records written by any of several, picking
+// the one to read a record with by the record's first byte. A store can thus hold records in mixed formats,
+// for instance while moving from one format to another: records in the old format stay readable while new
+// ones are written in the new format.
+type CompositeSerializer struct {
+	encoder  FormatSerializer
+	decoders map[byte]FormatSerializer
+}
+
+// MarshalEvent converts an event into a Record with the serializer that writes records
+func (c *CompositeSerializer) MarshalEvent(event Event) (eventstore.Record, error) {
+	return c.encoder.MarshalEvent(event)
+}
+
+// UnmarshalEvent converts a Record into an Event with the serializer of the record's format
+func (c *CompositeSerializer) UnmarshalEvent(record eventstore.Record) (Event, error) {
+	if len(record.Data) == 0 {
+		return nil, errors.New("unable to tell the format of an empty record")
+	}
+	decoder, ok := c.decoders[record.Data[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown record format 0x%02x", record.Data[0])
+	}
+	return decoder.UnmarshalEvent(record)
+}
+
+// NewCompositeSerializer constructs a CompositeSerializer that writes records with encoder and reads the
+// records of encoder and decoders. The encoder reads the records of its own format.
+func NewCompositeSerializer(encoder FormatSerializer, decoders ...FormatSerializer) *CompositeSerializer {
+	serializer := &CompositeSerializer{
+		encoder:  encoder,
+		decoders: map[byte]FormatSerializer{},
+	}
+	for _, decoder := range decoders {
+		serializer.decoders[decoder.Format()] = decoder
+	}
+	serializer.decoders[encoder.Format()] = encoder
+
+	return serializer
+}
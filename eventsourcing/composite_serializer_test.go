@@ -0,0 +1,35 @@
+package eventsourcing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompositeSerializer(t *testing.T) {
+	serializer := NewCompositeSerializer(NewJSONSerializer(TodoCreated{}, TodoDone{}))
+
+	t.Run("test JSON records start with the JSON format", func(ct *testing.T) {
+		created := TodoCreated{Model: Model{ID: uuid.NewV4().String(), Version: 1, At: time.Now()}, Desc: "Do this"}
+		record, err := serializer.MarshalEvent(created)
+		assert.NoError(ct, err)
+		assert.Equal(ct, FormatJSON, record.Data[0])
+
+		event, err := serializer.UnmarshalEvent(record)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", event.(*TodoCreated).Desc)
+	})
+
+	t.Run("test an empty record (error)", func(ct *testing.T) {
+		_, err := serializer.UnmarshalEvent(eventstore.Record{Version: 1})
+		assert.Error(ct, err)
+	})
+
+	t.Run("test a record in an unknown format (error)", func(ct *testing.T) {
+		_, err := serializer.UnmarshalEvent(eventstore.Record{Version: 1, Data: []byte{0xff}})
+		assert.Error(ct, err)
+	})
+}
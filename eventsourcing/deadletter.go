@@ -0,0 +1,123 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithDeadLetters makes the Repository keep the events its observers fail to observe in store, one dead letter
+// per event and observer, before OnObserveFailed is called. List, inspect and discard them with the store;
+// Redrive has the observer observe one again. A dead letter names its observer, so observers must have
+// different names, see NamedObserver; otherwise no dead letter is kept and Redrive fails, with
+// ErrDuplicateObserverName.
+func WithDeadLetters(store eventstore.DeadLetterStore) Option {
+	return func(r *Repository) {
+		r.deadLetters = store
+	}
+}
+
+// uniqueObserverNames returns ErrDuplicateObserverName when two observers have the same name, as their dead
+// letters would be mixed up
+func uniqueObserverNames(observers []Observer) error {
+	seen := make(map[string]bool, len(observers))
+	for _, observer := range observers {
+		name := observerName(observer)
+		if seen[name] {
+			return fmt.Errorf("%w: %s", ErrDuplicateObserverName, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// keepDeadLetters saves a dead letter for each of the events an observer failed to observe, holding the record
+// the event was saved as. The dead letters are saved even when ctx ended, which may be why observing failed.
+func (r *Repository) keepDeadLetters(
+	ctx context.Context,
+	observer Observer,
+	events []Event,
+	records []eventstore.Record,
+	attempts int,
+	err error,
+) error {
+	if r.deadLetters == nil {
+		return nil
+	}
+	if r.duplicateName != nil {
+		return r.duplicateName
+	}
+	ctx = detachedContext{ctx}
+	now := time.Now()
+	for i, event := range events {
+		letter := eventstore.DeadLetter{
+			ID:          uuid.NewV4().String(),
+			Observer:    observerName(observer),
+			AggregateID: event.AggregateID(),
+			Record:      records[i],
+			Error:       err.Error(),
+			Attempts:    attempts,
+			FailedAt:    now,
+		}
+		if saveErr := r.deadLetters.SaveDeadLetter(ctx, letter); saveErr != nil {
+			return saveErr
+		}
+	}
+	return nil
+}
+
+// Redrive has the observer of a dead letter observe its event again, along with the current state of the
+// aggregate, and discards the dead letter once it did. When the observer fails again, the dead letter is kept
+// with the new error and one more attempt, and the error is returned.
+func (r *Repository) Redrive(ctx context.Context, deadLetterID string) error {
+	if r.deadLetters == nil {
+		return errors.New("the repository keeps no dead letters, see WithDeadLetters")
+	}
+	if r.duplicateName != nil {
+		return r.duplicateName
+	}
+	letter, err := r.deadLetters.LoadDeadLetter(ctx, deadLetterID)
+	if err != nil {
+		return err
+	}
+
+	var observer Observer
+	for _, o := range r.observers {
+		if observerName(o) == letter.Observer {
+			observer = o
+			break
+		}
+	}
+	if observer == nil {
+		return fmt.Errorf("the repository has no observer %s", letter.Observer)
+	}
+
+	event, err := r.serializer.UnmarshalEvent(letter.Record)
+	if err != nil {
+		return err
+	}
+	aggregate, err := r.Load(ctx, letter.AggregateID)
+	if err != nil {
+		return err
+	}
+
+	if batchObserver, ok := observer.(BatchObserver); ok {
+		err = batchObserver.ObserveBatch(ctx, aggregate, []Event{event})
+	} else {
+		err = observer.Observe(ctx, aggregate, event)
+	}
+	if err != nil {
+		letter.Error = err.Error()
+		letter.Attempts++
+		letter.FailedAt = time.Now()
+		if saveErr := r.deadLetters.SaveDeadLetter(ctx, *letter); saveErr != nil {
+			return fmt.Errorf("%w; unable to keep the dead letter: %v", err, saveErr)
+		}
+		return err
+	}
+	return r.deadLetters.DeleteDeadLetter(ctx, deadLetterID)
+}
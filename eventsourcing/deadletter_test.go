@@ -0,0 +1,182 @@
+package eventsourcing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedObserver is an asyncObserver with a name, so there can be several of them
+type namedObserver struct {
+	asyncObserver
+	name string
+}
+
+func (o *namedObserver) Name() string {
+	return o.name
+}
+
+// contextDeadLetterStore is a DeadLetterStore that, like stores over the network, fails once its context ended
+type contextDeadLetterStore struct {
+	eventstore.DeadLetterStore
+}
+
+func (s *contextDeadLetterStore) SaveDeadLetter(ctx context.Context, letter eventstore.DeadLetter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.DeadLetterStore.SaveDeadLetter(ctx, letter)
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+
+	t.Run("a failed observation is kept and re-driven", func(ct *testing.T) {
+		store := eventstore.GetLocalStore()
+		deadLetters := eventstore.GetLocalDeadLetterStore()
+		observer := &asyncObserver{failures: 2}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, []Observer{observer},
+			WithDeadLetters(deadLetters))
+
+		var id = uuid.NewV4().String()
+		requestCtx := ContextWithCorrelationID(ctx, "request-1")
+		_, err := repo.Apply(requestCtx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Len(ct, observer.failed, 1)
+
+		letters, err := deadLetters.ListDeadLetters(ctx)
+		assert.NoError(ct, err)
+		assert.Len(ct, letters, 1)
+		letter := letters[0]
+		assert.Equal(ct, "asyncObserver", letter.Observer)
+		assert.Equal(ct, id, letter.AggregateID)
+		assert.Equal(ct, "TodoCreated", letter.Record.EventType)
+		assert.Equal(ct, 1, letter.Record.Version)
+
+		// The dead letter holds the record that was saved, metadata included
+		history, err := store.Load(ctx, id, 0, 0)
+		assert.NoError(ct, err)
+		assert.Equal(ct, history[0].EventID, letter.Record.EventID)
+		assert.Equal(ct, "request-1", letter.Record.CorrelationID)
+		assert.Equal(ct, "webhook unavailable", letter.Error)
+		assert.Equal(ct, 1, letter.Attempts)
+
+		// The observer fails once more, so the dead letter stays
+		assert.EqualError(ct, repo.Redrive(ctx, letter.ID), "webhook unavailable")
+		kept, err := deadLetters.LoadDeadLetter(ctx, letter.ID)
+		assert.NoError(ct, err)
+		assert.Equal(ct, 2, kept.Attempts)
+
+		assert.NoError(ct, repo.Redrive(ctx, letter.ID))
+		assert.Equal(ct, map[string][]int{id: {1}}, observer.versions)
+		_, err = deadLetters.LoadDeadLetter(ctx, letter.ID)
+		assert.ErrorIs(ct, err, eventstore.ErrDeadLetterNotFound)
+	})
+
+	t.Run("dead letters count the retries of async observers", func(ct *testing.T) {
+		deadLetters := eventstore.GetLocalDeadLetterStore()
+		observer := &asyncObserver{failures: 5}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, []Observer{observer},
+			WithAsyncObservers(WithObserverRetry(RetryPolicy{MaxAttempts: 3})), WithDeadLetters(deadLetters))
+
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.NoError(ct, repo.Close(ctx))
+
+		letters, _ := deadLetters.ListDeadLetters(ctx)
+		assert.Len(ct, letters, 1)
+		assert.Equal(ct, 3, letters[0].Attempts)
+	})
+
+	t.Run("observers of the same type are told apart by name", func(ct *testing.T) {
+		deadLetters := eventstore.GetLocalDeadLetterStore()
+		healthy := &namedObserver{name: "audit"}
+		broken := &namedObserver{name: "webhook", asyncObserver: asyncObserver{failures: 1}}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer,
+			[]Observer{healthy, broken}, WithDeadLetters(deadLetters))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		letters, _ := deadLetters.ListDeadLetters(ctx)
+		assert.Len(ct, letters, 1)
+		assert.Equal(ct, "webhook", letters[0].Observer)
+
+		assert.NoError(ct, repo.Redrive(ctx, letters[0].ID))
+		assert.Equal(ct, map[string][]int{id: {1}}, healthy.versions)
+		assert.Equal(ct, map[string][]int{id: {1}}, broken.versions)
+	})
+
+	t.Run("observers with the same name need no name without dead letters", func(ct *testing.T) {
+		first, second := &asyncObserver{}, &asyncObserver{}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer,
+			[]Observer{first, second})
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Equal(ct, map[string][]int{id: {1}}, first.versions)
+		assert.Equal(ct, map[string][]int{id: {1}}, second.versions)
+	})
+
+	t.Run("observers with the same name keep no dead letters (error)", func(ct *testing.T) {
+		deadLetters := eventstore.GetLocalDeadLetterStore()
+		broken := &namedObserver{name: "webhook", asyncObserver: asyncObserver{failures: 1}}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer,
+			[]Observer{&namedObserver{name: "webhook"}, broken}, WithDeadLetters(deadLetters))
+
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Len(ct, broken.failed, 1)
+		assert.Contains(ct, broken.failed[0].Error(), ErrDuplicateObserverName.Error())
+
+		letters, _ := deadLetters.ListDeadLetters(ctx)
+		assert.Empty(ct, letters)
+		assert.ErrorIs(ct, repo.Redrive(ctx, uuid.NewV4().String()), ErrDuplicateObserverName)
+	})
+
+	t.Run("events that could not be queued are kept after their command ended", func(ct *testing.T) {
+		deadLetters := &contextDeadLetterStore{DeadLetterStore: eventstore.GetLocalDeadLetterStore()}
+		observer := &asyncObserver{release: make(chan struct{})}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, []Observer{observer},
+			WithAsyncObservers(WithObserverWorkers(1), WithObserverQueueSize(1)), WithDeadLetters(deadLetters))
+
+		// The first event keeps the worker busy and the second one fills the queue
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = repo.Apply(timeoutCtx, &MarkUndone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		close(observer.release)
+		assert.NoError(ct, repo.Close(ctx))
+		letters, _ := deadLetters.ListDeadLetters(ctx)
+		assert.Len(ct, letters, 1)
+		assert.Equal(ct, 3, letters[0].Record.Version)
+		assert.Len(ct, observer.failed, 1)
+		assert.ErrorIs(ct, observer.failed[0], context.DeadlineExceeded)
+	})
+
+	t.Run("Redrive of an unknown dead letter (error)", func(ct *testing.T) {
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil,
+			WithDeadLetters(eventstore.GetLocalDeadLetterStore()))
+		assert.ErrorIs(ct, repo.Redrive(ctx, uuid.NewV4().String()), eventstore.ErrDeadLetterNotFound)
+	})
+
+	t.Run("Redrive without WithDeadLetters (error)", func(ct *testing.T) {
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil)
+		assert.Error(ct, repo.Redrive(ctx, uuid.NewV4().String()))
+	})
+}
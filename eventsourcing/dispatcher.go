@@ -0,0 +1,259 @@
+package eventsourcing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+const (
+	// DefaultObserverWorkers is how many workers notify each observer, unless WithObserverWorkers says otherwise
+	DefaultObserverWorkers = 4
+
+	// DefaultObserverQueueSize is how many notifications wait for each observer, unless WithObserverQueueSize
+	// says otherwise
+	DefaultObserverQueueSize = 1024
+)
+
+// DispatchOption configures how WithAsyncObservers notifies observers
+type DispatchOption func(*dispatcher)
+
+// WithObserverWorkers sets how many workers notify each observer
+func WithObserverWorkers(n int) DispatchOption {
+	return func(d *dispatcher) {
+		d.workers = n
+	}
+}
+
+// WithObserverQueueSize sets how many notifications may wait for each observer. Apply blocks while the queue of
+// an observer is full, and gives up on notifying it when its context ends or Close is called first.
+func WithObserverQueueSize(n int) DispatchOption {
+	return func(d *dispatcher) {
+		d.queueSize = n
+	}
+}
+
+// WithObserverRetry sets how often an observer is retried, and how long to wait in between, before its
+// OnObserveFailed is called. The Retryable field of policy is not used.
+func WithObserverRetry(policy RetryPolicy) DispatchOption {
+	return func(d *dispatcher) {
+		d.retry = policy
+	}
+}
+
+// WithAsyncObservers makes the Repository notify its observers in the background, so a slow observer does not
+// hold up commands. Every observer has a queue and workers of its own; the events of an aggregate are always
+// observed by the same worker, in the order they were saved. Observers get a copy of the aggregate Apply
+// returns, made like cached state: with encoding/json, or Clone for a CloneableAggregate.
+// Close stops the workers once they observed what was queued. Without this option, observers are notified
+// before Apply returns, which keeps tests simple.
+func WithAsyncObservers(opts ...DispatchOption) Option {
+	return func(r *Repository) {
+		d := &dispatcher{
+			workers:   DefaultObserverWorkers,
+			queueSize: DefaultObserverQueueSize,
+			retry:     DefaultRetryPolicy,
+		}
+		for _, opt := range opts {
+			opt(d)
+		}
+		r.dispatcher = d
+	}
+}
+
+// Close stops notifying observers in the background, once every event queued for them was observed. It returns
+// the error of ctx when ctx ends first. Without WithAsyncObservers it does nothing.
+func (r *Repository) Close(ctx context.Context) error {
+	if r.dispatcher == nil {
+		return nil
+	}
+	return r.dispatcher.close(ctx)
+}
+
+// notification is the events of a command for an observer to observe
+type notification struct {
+	ctx         context.Context
+	aggregateID string
+	aggregate   Aggregate
+	events      []Event
+	records     []eventstore.Record
+}
+
+// dispatcher queues notifications for the workers of each observer. Each observer has a channel per worker,
+// and the events of an aggregate always go down the same one. Commands hold the sequence lock of that worker
+// from saving their events until they are queued, so events are queued in the order they were saved.
+// The channels are only closed once no dispatch is sending to them, so mux is never held while a dispatch waits
+// on a full queue.
+type dispatcher struct {
+	workers   int
+	queueSize int
+	retry     RetryPolicy
+	sequences []sync.Mutex
+
+	mux     sync.Mutex
+	closed  bool
+	closing chan struct{}
+	stopped chan struct{}
+	sending sync.WaitGroup
+	queues  [][]chan notification
+	wg      sync.WaitGroup
+}
+
+// start runs the workers of every observer of r
+func (d *dispatcher) start(r *Repository) {
+	if d.workers < 1 {
+		d.workers = 1
+	}
+	capacity := d.queueSize / d.workers
+	if capacity < 1 {
+		capacity = 1
+	}
+	d.closing = make(chan struct{})
+	d.stopped = make(chan struct{})
+	d.sequences = make([]sync.Mutex, d.workers)
+
+	for _, observer := range r.observers {
+		channels := make([]chan notification, d.workers)
+		for i := range channels {
+			channels[i] = make(chan notification, capacity)
+			d.wg.Add(1)
+			go func(observer Observer, ch <-chan notification) {
+				defer d.wg.Done()
+				for n := range ch {
+					r.observeAsync(n, observer, &d.retry)
+				}
+			}(observer, channels[i])
+		}
+		d.queues = append(d.queues, channels)
+	}
+}
+
+// dispatch queues the events of a command for every observer of r. While a queue is full it waits for room,
+// for ctx to end or for the dispatcher to close, whichever comes first.
+func (d *dispatcher) dispatch(ctx context.Context, r *Repository, n notification) {
+	d.mux.Lock()
+	if d.closed {
+		d.mux.Unlock()
+		for _, observer := range r.observers {
+			r.dispatchFailed(observer, n, ErrDispatcherClosed)
+		}
+		return
+	}
+	d.sending.Add(1)
+	d.mux.Unlock()
+	defer d.sending.Done()
+
+	worker := d.worker(n.aggregateID)
+	for i, observer := range r.observers {
+		queue := d.queues[i][worker]
+		select {
+		case queue <- n:
+			continue
+		default:
+		}
+		select {
+		case queue <- n:
+		case <-d.closing:
+			r.dispatchFailed(observer, n, ErrDispatcherClosed)
+		case <-ctx.Done():
+			r.dispatchFailed(observer, n, fmt.Errorf("observer queue is full: %w", ctx.Err()))
+		}
+	}
+}
+
+// worker returns the worker that observes the events of an aggregate
+func (d *dispatcher) worker(aggregateID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(aggregateID))
+	return int(h.Sum32() % uint32(d.workers))
+}
+
+// sequence takes the sequence lock of the worker of an aggregate and returns the function that releases it.
+// Without a dispatcher it does nothing.
+func (d *dispatcher) sequence(aggregateID string) func() {
+	if d == nil {
+		return func() {}
+	}
+	mux := &d.sequences[d.worker(aggregateID)]
+	mux.Lock()
+	return mux.Unlock
+}
+
+// dispatchFailed reports that events could not be queued for an observer, unless it would not observe them.
+// It goes by the context of the notification, as the context of the command may be why queueing failed.
+func (r *Repository) dispatchFailed(observer Observer, n notification, err error) {
+	if events, records := willObserve(n.ctx, observer, n.aggregate, n.events, n.records); len(events) > 0 {
+		r.observerFailed(n.ctx, nil, observer, events, records, 0, err)
+	}
+}
+
+// close stops taking notifications and waits for the workers to observe the queued ones. Dispatches waiting on
+// a full queue give up, and the channels are closed once they did.
+func (d *dispatcher) close(ctx context.Context) error {
+	d.mux.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.closing)
+		go func() {
+			d.sending.Wait()
+			for _, channels := range d.queues {
+				for _, ch := range channels {
+					close(ch)
+				}
+			}
+			d.wg.Wait()
+			close(d.stopped)
+		}()
+	}
+	d.mux.Unlock()
+
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observeAsync has an observer observe a queued notification
+func (r *Repository) observeAsync(n notification, observer Observer, retry *RetryPolicy) {
+	ctx, span := r.startSpan(n.ctx, "Repository.Observe",
+		eventstore.AggregateIDKey.String(n.aggregateID),
+		observerCountKey.Int(1),
+	)
+	defer span.End()
+	r.observe(ctx, span, observer, n.aggregate, n.events, n.records, retry)
+}
+
+// copyAggregate clones a CloneableAggregate or copies an aggregate through encoding/json, and returns the
+// aggregate itself when that fails
+func (r *Repository) copyAggregate(aggregate Aggregate) Aggregate {
+	if cloneable, ok := aggregate.(CloneableAggregate); ok {
+		return cloneable.Clone()
+	}
+	data, err := json.Marshal(aggregate)
+	if err != nil {
+		return aggregate
+	}
+	copied := r.newPrototype()
+	if err = json.Unmarshal(data, copied); err != nil {
+		return aggregate
+	}
+	return copied
+}
+
+// detachedContext keeps the values of a context, like its span and metadata, but not its deadline or
+// cancellation, so observers in the background outlive the command that queued their events
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,228 @@
+package eventsourcing
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// asyncObserver is an Observer, safe for concurrent use, that remembers the versions it observed by aggregate.
+// It waits for release before observing, when there is one, and fails as often as failures says first.
+type asyncObserver struct {
+	mux      sync.Mutex
+	release  chan struct{}
+	failures int
+	attempts int
+	versions map[string][]int
+	failed   []error
+}
+
+func (o *asyncObserver) WillObserve(context.Context, Aggregate, Event) bool {
+	return true
+}
+
+func (o *asyncObserver) Observe(_ context.Context, _ Aggregate, e Event) error {
+	if o.release != nil {
+		<-o.release
+	}
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.attempts++
+	if o.failures > 0 {
+		o.failures--
+		return errors.New("webhook unavailable")
+	}
+	if o.versions == nil {
+		o.versions = map[string][]int{}
+	}
+	o.versions[e.AggregateID()] = append(o.versions[e.AggregateID()], e.EventVersion())
+	return nil
+}
+
+func (o *asyncObserver) OnObserveFailed(_ context.Context, err error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.failed = append(o.failed, err)
+}
+
+// jitteryStore is an EventStore whose loads take a random while, like a store over the network
+type jitteryStore struct {
+	eventstore.EventStore
+}
+
+func (j jitteryStore) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (eventstore.History, error) {
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+	return j.EventStore.Load(ctx, aggregateID, fromVersion, toVersion)
+}
+
+func TestAsyncObservers(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	retry := WithObserverRetry(RetryPolicy{MaxAttempts: 3})
+	newRepo := func(observer Observer, opts ...DispatchOption) *Repository {
+		return NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, []Observer{observer},
+			WithAsyncObservers(opts...))
+	}
+
+	t.Run("a slow observer does not hold up commands", func(ct *testing.T) {
+		observer := &asyncObserver{release: make(chan struct{})}
+		repo := newRepo(observer)
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		close(observer.release)
+		assert.NoError(ct, repo.Close(ctx))
+		assert.Equal(ct, map[string][]int{id: {1, 2}}, observer.versions)
+	})
+
+	t.Run("events of an aggregate are observed in order", func(ct *testing.T) {
+		observer := &asyncObserver{}
+		repo := newRepo(observer, WithObserverWorkers(3), WithObserverQueueSize(2))
+
+		var wg sync.WaitGroup
+		ids := make([]string, 5)
+		for i := range ids {
+			ids[i] = uuid.NewV4().String()
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				_, _ = repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+				for j := 0; j < 5; j++ {
+					_, _ = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+					_, _ = repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+				}
+			}(ids[i])
+		}
+		wg.Wait()
+
+		assert.NoError(ct, repo.Close(ctx))
+		for _, id := range ids {
+			assert.Equal(ct, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, observer.versions[id])
+		}
+	})
+
+	t.Run("concurrent commands on an aggregate are observed in the order they were saved", func(ct *testing.T) {
+		observer := &asyncObserver{}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), jitteryStore{eventstore.GetLocalStore()}, serializer,
+			[]Observer{observer}, WithAsyncObservers(WithObserverWorkers(2)), WithConflictRetry(RetryPolicy{MaxAttempts: 50}))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					if (i+j)%2 == 0 {
+						_, _ = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+					} else {
+						_, _ = repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.NoError(ct, repo.Close(ctx))
+
+		agg, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		expected := make([]int, agg.(*MyTodo).Version)
+		for i := range expected {
+			expected[i] = i + 1
+		}
+		assert.Equal(ct, expected, observer.versions[id])
+	})
+
+	t.Run("an observer is retried before it fails", func(ct *testing.T) {
+		flaky := &asyncObserver{failures: 2}
+		repo := newRepo(flaky, retry)
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.NoError(ct, repo.Close(ctx))
+		assert.Equal(ct, 3, flaky.attempts)
+		assert.Empty(ct, flaky.failed)
+
+		broken := &asyncObserver{failures: 5}
+		repo = newRepo(broken, retry)
+		_, err = repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.NoError(ct, repo.Close(ctx))
+		assert.Equal(ct, 3, broken.attempts)
+		assert.Len(ct, broken.failed, 1)
+		assert.EqualError(ct, broken.failed[0], "webhook unavailable")
+	})
+
+	t.Run("events saved after Close are not observed (error)", func(ct *testing.T) {
+		observer := &asyncObserver{}
+		repo := newRepo(observer)
+		assert.NoError(ct, repo.Close(ctx))
+		assert.NoError(ct, repo.Close(ctx))
+
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Empty(ct, observer.versions)
+		assert.Len(ct, observer.failed, 1)
+		assert.ErrorIs(ct, observer.failed[0], ErrDispatcherClosed)
+	})
+
+	t.Run("Close gives up with its context (error)", func(ct *testing.T) {
+		observer := &asyncObserver{release: make(chan struct{})}
+		defer close(observer.release)
+		repo := newRepo(observer)
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: uuid.NewV4().String()}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(ct, repo.Close(timeoutCtx), context.DeadlineExceeded)
+	})
+
+	t.Run("Close does not wait for Apply on a full queue (error)", func(ct *testing.T) {
+		observer := &asyncObserver{release: make(chan struct{})}
+		repo := newRepo(observer, WithObserverWorkers(1), WithObserverQueueSize(1))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		applied := make(chan error)
+		go func() {
+			_, err := repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+			applied <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(ct, repo.Close(timeoutCtx), context.DeadlineExceeded)
+		assert.NoError(ct, <-applied)
+
+		close(observer.release)
+		assert.NoError(ct, repo.Close(ctx))
+		assert.Equal(ct, map[string][]int{id: {1, 2}}, observer.versions)
+		assert.Len(ct, observer.failed, 1)
+		assert.ErrorIs(ct, observer.failed[0], ErrDispatcherClosed)
+	})
+
+	t.Run("Close does nothing without WithAsyncObservers", func(ct *testing.T) {
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil)
+		assert.NoError(ct, repo.Close(ctx))
+	})
+}
@@ -0,0 +1,25 @@
+package eventsourcing
+
+import (
+	"errors"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+// ErrAggregateNotFound is returned by Load and Update when the store holds no events of the aggregate.
+// It is eventstore.ErrAggregateNotFound, so errors.Is matches what repositories and stores return alike.
+var ErrAggregateNotFound = eventstore.ErrAggregateNotFound
+
+// ErrAggregateExists is returned by Create when the aggregate already has events
+var ErrAggregateExists = errors.New("aggregate already exists")
+
+// ErrUnstampedEvent is returned by Apply, Create and Update when a command emits an event the Repository cannot
+// stamp, like an event value rather than a pointer, and that does not carry the version that is its turn
+var ErrUnstampedEvent = errors.New("event cannot be stamped and has the wrong version")
+
+// ErrDispatcherClosed is passed to OnObserveFailed for events saved after Repository.Close
+var ErrDispatcherClosed = errors.New("observer dispatcher is closed")
+
+// ErrDuplicateObserverName is returned by Redrive, and passed to OnObserveFailed instead of keeping a dead
+// letter, when the Repository keeps dead letters and two of its observers have the same name
+var ErrDuplicateObserverName = errors.New("more than one observer has the same name")
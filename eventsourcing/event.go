@@ -19,3 +19,13 @@ type Event interface {
 	// EventType returns the type and unique name of event
 	EventType() (reflect.Type, string)
 }
+
+// StampableEvent is an Event the Repository numbers and dates itself. Events a command emits for its aggregate
+// are stamped with the versions that follow the aggregate's, in order, and the time the command was handled,
+// so command handlers need not fill them in. Model implements it on pointers, so emit events like &MyEvent{}.
+type StampableEvent interface {
+	Event
+
+	// StampEvent sets the version of the event and when it occurred
+	StampEvent(version int, at time.Time)
+}
@@ -11,18 +11,31 @@ import (
 type jsonEvent struct {
 	Type string          `json:"t"`
 	Data json.RawMessage `json:"d"`
+
+	// SchemaVersion is the version of the event's shape; events stored before it was recorded are at 1
+	SchemaVersion int `json:"v,omitempty"`
 }
 
 // JSONSerializer provides a simple serializer implementation
 type JSONSerializer struct {
-	eventTypes map[string]reflect.Type
+	eventTypes     map[string]reflect.Type
+	schemaVersions map[string]int
+	upcasters      map[upcasterKey]Upcaster
 }
 
-// Bind registers the specified events with the serializer; may be called more than once
+// Bind registers the specified events with the serializer at schema version 1; may be called more than once
 func (j *JSONSerializer) Bind(events ...Event) {
+	j.BindVersion(1, events...)
+}
+
+// BindVersion registers the specified events with the serializer at a schema version.
+// Events are stored with the schema version they are bound at, and events stored at an older one are upcast
+// to it when unmarshaled.
+func (j *JSONSerializer) BindVersion(schemaVersion int, events ...Event) {
 	for _, event := range events {
 		eventType, t := event.EventType()
 		j.eventTypes[t] = eventType
+		j.schemaVersions[t] = schemaVersion
 	}
 }
 
@@ -36,20 +49,25 @@ func (j *JSONSerializer) MarshalEvent(ev Event) (eventstore.Record, error) {
 	}
 
 	recordData, err2 := json.Marshal(jsonEvent{
-		Type: eventType,
-		Data: json.RawMessage(data),
+		Type:          eventType,
+		Data:          json.RawMessage(data),
+		SchemaVersion: j.schemaVersions[eventType],
 	})
 	if err2 != nil {
 		return eventstore.Record{}, fmt.Errorf("unable to encode event")
 	}
 
 	return eventstore.Record{
-		Version: ev.EventVersion(),
-		Data:    recordData,
+		Version:    ev.EventVersion(),
+		Data:       recordData,
+		EventType:  eventType,
+		OccurredAt: ev.EventAt(),
 	}, nil
 }
 
-// UnmarshalEvent converts the persistent type, Record, into an Event instance
+// UnmarshalEvent converts the persistent type, Record, into an Event instance.
+// An event stored at an older schema version than its type is bound at goes through the registered upcasters
+// first.
 func (j *JSONSerializer) UnmarshalEvent(record eventstore.Record) (Event, error) {
 	wrapper := jsonEvent{}
 	err := json.Unmarshal(record.Data, &wrapper)
@@ -57,13 +75,18 @@ func (j *JSONSerializer) UnmarshalEvent(record eventstore.Record) (Event, error)
 		return nil, fmt.Errorf("unable to unmarshal event")
 	}
 
-	t, ok := j.eventTypes[wrapper.Type]
+	payload, err := j.upcast(EventPayload{Type: wrapper.Type, SchemaVersion: wrapper.SchemaVersion, Data: wrapper.Data})
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := j.eventTypes[payload.Type]
 	if !ok {
-		return nil, fmt.Errorf("unbound event type, %v", wrapper.Type)
+		return nil, fmt.Errorf("unbound event type, %v", payload.Type)
 	}
 
 	v := reflect.New(t).Interface()
-	err = json.Unmarshal(wrapper.Data, v)
+	err = json.Unmarshal(payload.Data, v)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal event data into %#v", v)
 	}
@@ -75,7 +98,9 @@ func (j *JSONSerializer) UnmarshalEvent(record eventstore.Record) (Event, error)
 // Bind may be subsequently called to add more events.
 func NewJSONSerializer(events ...Event) *JSONSerializer {
 	serializer := &JSONSerializer{
-		eventTypes: map[string]reflect.Type{},
+		eventTypes:     map[string]reflect.Type{},
+		schemaVersions: map[string]int{},
+		upcasters:      map[upcasterKey]Upcaster{},
 	}
 	serializer.Bind(events...)
 
@@ -0,0 +1,84 @@
+package eventsourcing
+
+import (
+	"context"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+)
+
+type metadataKey int
+
+const (
+	correlationIDKey metadataKey = iota
+	causationIDKey
+	headersKey
+)
+
+// ContextWithCorrelationID returns a context under which the Repository saves events with the correlation id
+func ContextWithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, correlationID)
+}
+
+// ContextWithCausationID returns a context under which the Repository saves events with the causation id
+func ContextWithCausationID(ctx context.Context, causationID string) context.Context {
+	return context.WithValue(ctx, causationIDKey, causationID)
+}
+
+// ContextWithHeaders returns a context under which the Repository saves events with the headers,
+// in addition to the headers the context already carries
+func ContextWithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	merged := map[string]string{}
+	for k, v := range HeadersFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headersKey, merged)
+}
+
+// CorrelationIDFromContext returns the correlation id set with ContextWithCorrelationID, if any
+func CorrelationIDFromContext(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey).(string)
+	return correlationID
+}
+
+// CausationIDFromContext returns the causation id set with ContextWithCausationID, if any
+func CausationIDFromContext(ctx context.Context) string {
+	causationID, _ := ctx.Value(causationIDKey).(string)
+	return causationID
+}
+
+// HeadersFromContext returns the headers set with ContextWithHeaders, if any
+func HeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey).(map[string]string)
+	return headers
+}
+
+// stamp fills in the metadata of a record that the serializer cannot know: a new event id, and the ids and
+// headers carried by the context. Metadata the record already has is kept.
+func stamp(ctx context.Context, record *eventstore.Record) {
+	if record.EventID == "" {
+		record.EventID = uuid.NewV4().String()
+	}
+	if record.CorrelationID == "" {
+		record.CorrelationID = CorrelationIDFromContext(ctx)
+	}
+	if record.CausationID == "" {
+		record.CausationID = CausationIDFromContext(ctx)
+	}
+
+	headers := HeadersFromContext(ctx)
+	if len(headers) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(headers)+len(record.Headers))
+	for k, v := range headers {
+		merged[k] = v
+	}
+	for k, v := range record.Headers {
+		merged[k] = v
+	}
+	record.Headers = merged
+}
@@ -0,0 +1,42 @@
+package eventsourcing
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"github.com/cannahum/eventsourcing-lite/metrics"
+)
+
+// WithMetrics makes the Repository record with recorder how long each command took and how it ended,
+// how many events each load replayed and which observers failed.
+// Wrap the store with eventstore.GetMetricsStore to count the events it saves as well.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(r *Repository) {
+		r.metrics = recorder
+	}
+}
+
+// outcome tells how a command that ended with err went
+func outcome(err error) metrics.Outcome {
+	var conflict *eventstore.ErrConcurrencyConflict
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.As(err, &conflict):
+		return metrics.OutcomeConflict
+	}
+	return metrics.OutcomeError
+}
+
+// typeName names the type of v, or the type it points to
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
@@ -2,7 +2,7 @@ package eventsourcing
 
 import "time"
 
-// Model provides a default implementation of an Event
+// Model provides a default implementation of an Event, and of a StampableEvent through a pointer
 type Model struct {
 	// ID contains the AggregateID
 	ID string
@@ -28,3 +28,9 @@ func (m Model) EventVersion() int {
 func (m Model) EventAt() time.Time {
 	return m.At
 }
+
+// StampEvent implements the StampableEvent interface
+func (m *Model) StampEvent(version int, at time.Time) {
+	m.Version = version
+	m.At = at
+}
@@ -1,9 +1,148 @@
 package eventsourcing
 
-import "context"
+import (
+	"context"
+	"fmt"
 
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Observer is notified of the events a command emitted, once they are saved. It observes each of them in
+// order, along with the state of the aggregate after the command, which is its state as of the last event.
 type Observer interface {
 	WillObserve(context.Context, Aggregate, Event) bool
 	Observe(context.Context, Aggregate, Event) error
 	OnObserveFailed(context.Context, error)
 }
+
+// BatchObserver is an Observer that observes the events of a command all at once rather than one by one.
+// ObserveBatch gets the events WillObserve accepted, in order, and is not called when it accepted none.
+type BatchObserver interface {
+	Observer
+	ObserveBatch(context.Context, Aggregate, []Event) error
+}
+
+// NamedObserver is an Observer with a name of its own. Dead letters and metrics tell observers apart by name,
+// which is the name of their type unless they implement NamedObserver, so observers of the same type need one.
+type NamedObserver interface {
+	Observer
+	Name() string
+}
+
+// observerName names an observer by its Name, or by the name of its type
+func observerName(observer Observer) string {
+	if named, ok := observer.(NamedObserver); ok {
+		return named.Name()
+	}
+	return typeName(observer)
+}
+
+// notify has every observer observe the events a command emitted, or queues them to with WithAsyncObservers.
+// records are the events as they were saved, in the same order.
+func (r *Repository) notify(
+	ctx context.Context,
+	aggregateID string,
+	aggregate Aggregate,
+	events []Event,
+	records []eventstore.Record,
+) {
+	if r.dispatcher != nil {
+		r.dispatcher.dispatch(ctx, r, notification{
+			ctx:         detachedContext{ctx},
+			aggregateID: aggregateID,
+			aggregate:   r.copyAggregate(aggregate),
+			events:      events,
+			records:     records,
+		})
+		return
+	}
+
+	ctx, span := r.startSpan(ctx, "Repository.Observe",
+		eventstore.AggregateIDKey.String(aggregateID),
+		observerCountKey.Int(len(r.observers)),
+	)
+	defer span.End()
+	for _, observer := range r.observers {
+		r.observe(ctx, span, observer, aggregate, events, records, nil)
+	}
+}
+
+// observe has an observer observe events, trying each as often as retry allows; a nil retry tries once.
+// A failing event does not keep the observer from observing the remaining ones.
+func (r *Repository) observe(
+	ctx context.Context,
+	span trace.Span,
+	observer Observer,
+	aggregate Aggregate,
+	events []Event,
+	records []eventstore.Record,
+	retry *RetryPolicy,
+) {
+	if batchObserver, ok := observer.(BatchObserver); ok {
+		batch, batchRecords := willObserve(ctx, observer, aggregate, events, records)
+		if len(batch) == 0 {
+			return
+		}
+		attempts, err := retry.do(ctx, func() error {
+			return batchObserver.ObserveBatch(ctx, aggregate, batch)
+		})
+		if err != nil {
+			r.observerFailed(ctx, span, observer, batch, batchRecords, attempts, err)
+		}
+		return
+	}
+
+	for i, event := range events {
+		if !observer.WillObserve(ctx, aggregate, event) {
+			continue
+		}
+		event := event
+		attempts, err := retry.do(ctx, func() error {
+			return observer.Observe(ctx, aggregate, event)
+		})
+		if err != nil {
+			r.observerFailed(ctx, span, observer, []Event{event}, records[i:i+1], attempts, err)
+		}
+	}
+}
+
+// willObserve returns the events an observer will observe, in order, along with their records
+func willObserve(
+	ctx context.Context,
+	observer Observer,
+	aggregate Aggregate,
+	events []Event,
+	records []eventstore.Record,
+) ([]Event, []eventstore.Record) {
+	var accepted []Event
+	var acceptedRecords []eventstore.Record
+	for i, event := range events {
+		if observer.WillObserve(ctx, aggregate, event) {
+			accepted = append(accepted, event)
+			acceptedRecords = append(acceptedRecords, records[i])
+		}
+	}
+	return accepted, acceptedRecords
+}
+
+// observerFailed reports that an observer failed to observe events after attempts tries, to the observer itself
+// and on span when there is one. With WithDeadLetters, the events are kept as dead letters too.
+func (r *Repository) observerFailed(
+	ctx context.Context,
+	span trace.Span,
+	observer Observer,
+	events []Event,
+	records []eventstore.Record,
+	attempts int,
+	err error,
+) {
+	if deadLetterErr := r.keepDeadLetters(ctx, observer, events, records, attempts, err); deadLetterErr != nil {
+		err = fmt.Errorf("%w; unable to keep dead letters: %v", err, deadLetterErr)
+	}
+	if span != nil {
+		span.RecordError(err)
+	}
+	r.metrics.ObserverFailed(observerName(observer))
+	observer.OnObserveFailed(ctx, err)
+}
@@ -200,3 +200,75 @@ func TestApplyObservers(t *testing.T) {
 	assert.Equal(t, 3, doneObserver.howManyTimes)
 	assert.True(t, failingObserver.hasFailed)
 }
+
+// recordingObserver remembers the types of the events it observed, and the versions of the aggregates with them
+type recordingObserver struct {
+	observed []string
+	failures int
+}
+
+func (o *recordingObserver) WillObserve(_ context.Context, _ Aggregate, e Event) bool {
+	_, isUndone := e.(*TodoUndone)
+	return !isUndone
+}
+
+func (o *recordingObserver) Observe(_ context.Context, a Aggregate, e Event) error {
+	_, eventType := e.EventType()
+	o.observed = append(o.observed, fmt.Sprintf("%s@%d", eventType, a.(*stampedTodo).Version))
+	if eventType == "TodoCreated" {
+		return errors.New("observer failed on TodoCreated")
+	}
+	return nil
+}
+
+func (o *recordingObserver) OnObserveFailed(_ context.Context, _ error) {
+	o.failures++
+}
+
+// recordingBatchObserver is a recordingObserver that observes the events of a command at once
+type recordingBatchObserver struct {
+	recordingObserver
+	batches [][]string
+}
+
+func (o *recordingBatchObserver) ObserveBatch(_ context.Context, _ Aggregate, events []Event) error {
+	var batch []string
+	for _, e := range events {
+		_, eventType := e.EventType()
+		batch = append(batch, eventType)
+	}
+	o.batches = append(o.batches, batch)
+	return nil
+}
+
+func TestObserveEveryEvent(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	observer := &recordingObserver{}
+	batchObserver := &recordingBatchObserver{}
+	repo := NewTypedRepository(
+		func() *stampedTodo { return &stampedTodo{} },
+		eventstore.GetLocalStore(),
+		serializer,
+		[]Observer{observer, batchObserver},
+	)
+
+	var id = uuid.NewV4().String()
+	_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this and observe"})
+	assert.NoError(t, err)
+	_, err = repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+	assert.NoError(t, err)
+
+	t.Run("every event is observed in order, with the final state", func(ct *testing.T) {
+		assert.Equal(ct, []string{"TodoCreated@2", "TodoDone@2"}, observer.observed)
+	})
+
+	t.Run("a failure does not stop the remaining events", func(ct *testing.T) {
+		assert.Equal(ct, 1, observer.failures)
+	})
+
+	t.Run("batch observers get the events they will observe at once", func(ct *testing.T) {
+		assert.Equal(ct, [][]string{{"TodoCreated", "TodoDone"}}, batchObserver.batches)
+		assert.Empty(ct, batchObserver.observed)
+	})
+}
@@ -0,0 +1,32 @@
+package testpb
+
+import (
+	"reflect"
+	"time"
+)
+
+// The messages of todo.proto are the events of the serializer tests
+
+// AggregateID implements the eventsourcing.Event interface
+func (x *TodoCreated) AggregateID() string { return x.GetId() }
+
+// EventVersion implements the eventsourcing.Event interface
+func (x *TodoCreated) EventVersion() int { return int(x.GetVersion()) }
+
+// EventAt implements the eventsourcing.Event interface
+func (x *TodoCreated) EventAt() time.Time { return time.Unix(0, x.GetAt()) }
+
+// EventType implements the eventsourcing.Event interface
+func (x *TodoCreated) EventType() (reflect.Type, string) { return reflect.TypeOf(x), "TodoCreated" }
+
+// AggregateID implements the eventsourcing.Event interface
+func (x *TodoDone) AggregateID() string { return x.GetId() }
+
+// EventVersion implements the eventsourcing.Event interface
+func (x *TodoDone) EventVersion() int { return int(x.GetVersion()) }
+
+// EventAt implements the eventsourcing.Event interface
+func (x *TodoDone) EventAt() time.Time { return time.Unix(0, x.GetAt()) }
+
+// EventType implements the eventsourcing.Event interface
+func (x *TodoDone) EventType() (reflect.Type, string) { return reflect.TypeOf(x), "TodoDone" }
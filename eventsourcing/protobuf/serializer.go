@@ -0,0 +1,128 @@
+// Package protobuf provides a Serializer for events that are Protocol Buffers messages.
+// Record.Data holds the binary encoding of the message and Record.EventType the name the event is bound
+// under, so consumers in any language can decode a record with the message type its event type stands for.
+// FormatSerializer writes the same records behind a FormatByte, for eventsourcing.CompositeSerializer.
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cannahum/eventsourcing-lite/eventsourcing"
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"google.golang.org/protobuf/proto"
+)
+
+// FormatByte is the first byte of the records the FormatSerializer writes. As a tag, it would stand for field
+// number 0, which no message has, so it never starts the binary encoding of a message.
+const FormatByte byte = 0x02
+
+// Event is an event that is a protobuf message, usually a generated message type with the methods of
+// eventsourcing.Event added in a separate file of its package
+type Event interface {
+	eventsourcing.Event
+	proto.Message
+}
+
+// Serializer converts between Events that are protobuf messages and Records
+type Serializer struct {
+	eventTypes map[string]reflect.Type
+}
+
+// Bind registers the specified events with the serializer; may be called more than once
+func (s *Serializer) Bind(events ...Event) {
+	for _, event := range events {
+		eventType, t := event.EventType()
+		// Generated messages implement their methods on pointers
+		if eventType.Kind() == reflect.Ptr {
+			eventType = eventType.Elem()
+		}
+		s.eventTypes[t] = eventType
+	}
+}
+
+// MarshalEvent converts an event into its persistent type, Record
+func (s *Serializer) MarshalEvent(ev eventsourcing.Event) (eventstore.Record, error) {
+	_, eventType := ev.EventType()
+	message, ok := ev.(proto.Message)
+	if !ok {
+		return eventstore.Record{}, fmt.Errorf("event type %v is not a protobuf message", eventType)
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return eventstore.Record{}, fmt.Errorf("unable to encode event: %w", err)
+	}
+
+	return eventstore.Record{
+		Version:    ev.EventVersion(),
+		Data:       data,
+		EventType:  eventType,
+		OccurredAt: ev.EventAt(),
+	}, nil
+}
+
+// UnmarshalEvent converts the persistent type, Record, into an Event instance
+func (s *Serializer) UnmarshalEvent(record eventstore.Record) (eventsourcing.Event, error) {
+	if record.EventType == "" {
+		return nil, fmt.Errorf("record %d has no event type", record.Version)
+	}
+	t, ok := s.eventTypes[record.EventType]
+	if !ok {
+		return nil, fmt.Errorf("unbound event type, %v", record.EventType)
+	}
+
+	v := reflect.New(t).Interface().(Event)
+	if err := proto.Unmarshal(record.Data, v); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal event data into %v: %w", record.EventType, err)
+	}
+	return v, nil
+}
+
+// NewSerializer constructs a new Serializer and populates it with the specified events.
+// Bind may be subsequently called to add more events.
+func NewSerializer(events ...Event) *Serializer {
+	serializer := &Serializer{
+		eventTypes: map[string]reflect.Type{},
+	}
+	serializer.Bind(events...)
+
+	return serializer
+}
+
+// FormatSerializer is a Serializer whose records start with FormatByte, followed by the binary encoding of the
+// message, so that eventsourcing.CompositeSerializer can tell them apart from records in other formats
+type FormatSerializer struct {
+	*Serializer
+}
+
+// Format implements the eventsourcing.FormatSerializer interface
+func (s *FormatSerializer) Format() byte {
+	return FormatByte
+}
+
+// MarshalEvent converts an event into its persistent type, Record
+func (s *FormatSerializer) MarshalEvent(ev eventsourcing.Event) (eventstore.Record, error) {
+	record, err := s.Serializer.MarshalEvent(ev)
+	if err != nil {
+		return eventstore.Record{}, err
+	}
+	record.Data = append([]byte{FormatByte}, record.Data...)
+	return record, nil
+}
+
+// UnmarshalEvent converts the persistent type, Record, into an Event instance
+func (s *FormatSerializer) UnmarshalEvent(record eventstore.Record) (eventsourcing.Event, error) {
+	if len(record.Data) == 0 || record.Data[0] != FormatByte {
+		return nil, errors.New("record is not in the protobuf format")
+	}
+	record.Data = record.Data[1:]
+	return s.Serializer.UnmarshalEvent(record)
+}
+
+// NewFormatSerializer constructs a new FormatSerializer and populates it with the specified events.
+// Bind may be subsequently called to add more events.
+func NewFormatSerializer(events ...Event) *FormatSerializer {
+	return &FormatSerializer{Serializer: NewSerializer(events...)}
+}
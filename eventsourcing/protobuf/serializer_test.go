@@ -0,0 +1,182 @@
+package protobuf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventsourcing"
+	"github.com/cannahum/eventsourcing-lite/eventsourcing/protobuf/internal/testpb"
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// fixture describes a record in testdata that consumers in other languages decode too
+type fixture struct {
+	EventType string          `json:"event_type"`
+	Message   string          `json:"message"`
+	Data      string          `json:"data"`
+	Fields    json.RawMessage `json:"fields"`
+}
+
+// plainEvent is an event that is not a protobuf message
+type plainEvent struct {
+	eventsourcing.Model
+}
+
+func (e plainEvent) EventType() (reflect.Type, string) {
+	return reflect.TypeOf(e), "PlainEvent"
+}
+
+func TestSerializer(t *testing.T) {
+	serializer := NewSerializer(&testpb.TodoCreated{}, &testpb.TodoDone{})
+	at := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	created := &testpb.TodoCreated{Id: uuid.NewV4().String(), Version: 1, At: at.UnixNano(), Desc: "Do this"}
+
+	t.Run("test MarshalEvent and UnmarshalEvent", func(ct *testing.T) {
+		record, err := serializer.MarshalEvent(created)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "TodoCreated", record.EventType)
+		assert.Equal(ct, 1, record.Version)
+		assert.True(ct, at.Equal(record.OccurredAt))
+
+		event, err := serializer.UnmarshalEvent(record)
+		assert.NoError(ct, err)
+		assert.True(ct, proto.Equal(created, event.(proto.Message)))
+	})
+
+	t.Run("test MarshalEvent of an event that is not a message (error)", func(ct *testing.T) {
+		_, err := serializer.MarshalEvent(plainEvent{eventsourcing.Model{ID: created.Id, Version: 1}})
+		assert.Error(ct, err)
+	})
+
+	t.Run("test UnmarshalEvent of an unbound event type (error)", func(ct *testing.T) {
+		record, _ := serializer.MarshalEvent(created)
+		_, err := NewSerializer(&testpb.TodoDone{}).UnmarshalEvent(record)
+		assert.Error(ct, err)
+	})
+
+	t.Run("test UnmarshalEvent of a record without an event type (error)", func(ct *testing.T) {
+		record, _ := serializer.MarshalEvent(created)
+		record.EventType = ""
+		_, err := serializer.UnmarshalEvent(record)
+		assert.Error(ct, err)
+	})
+
+	t.Run("test UnmarshalEvent of malformed data (error)", func(ct *testing.T) {
+		_, err := serializer.UnmarshalEvent(eventstore.Record{Version: 1, EventType: "TodoCreated", Data: []byte{0x0a, 0xff}})
+		assert.Error(ct, err)
+	})
+}
+
+func TestFormatSerializer(t *testing.T) {
+	serializer := NewFormatSerializer(&testpb.TodoCreated{}, &testpb.TodoDone{})
+	created := &testpb.TodoCreated{Id: uuid.NewV4().String(), Version: 1, At: time.Now().UnixNano(), Desc: "Do this"}
+
+	t.Run("test records are those of the Serializer behind the FormatByte", func(ct *testing.T) {
+		record, err := serializer.MarshalEvent(created)
+		assert.NoError(ct, err)
+		plain, err := serializer.Serializer.MarshalEvent(created)
+		assert.NoError(ct, err)
+		assert.Equal(ct, append([]byte{FormatByte}, plain.Data...), record.Data)
+
+		event, err := serializer.UnmarshalEvent(record)
+		assert.NoError(ct, err)
+		assert.True(ct, proto.Equal(created, event.(proto.Message)))
+	})
+
+	t.Run("test a CompositeSerializer reads records by format", func(ct *testing.T) {
+		jsonSerializer := eventsourcing.NewJSONSerializer()
+		composite := eventsourcing.NewCompositeSerializer(jsonSerializer, serializer)
+		record, _ := serializer.MarshalEvent(created)
+		event, err := composite.UnmarshalEvent(record)
+		assert.NoError(ct, err)
+		assert.True(ct, proto.Equal(created, event.(proto.Message)))
+	})
+
+	t.Run("test UnmarshalEvent of a record of the Serializer (error)", func(ct *testing.T) {
+		record, _ := serializer.Serializer.MarshalEvent(created)
+		_, err := serializer.UnmarshalEvent(record)
+		assert.Error(ct, err)
+	})
+}
+
+func TestFixtures(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("testdata", "fixtures.json"))
+	assert.NoError(t, err)
+	var fixtures []fixture
+	assert.NoError(t, json.Unmarshal(manifest, &fixtures))
+
+	serializer := NewSerializer(&testpb.TodoCreated{}, &testpb.TodoDone{})
+	for _, f := range fixtures {
+		t.Run("test the "+f.EventType+" fixture", func(ct *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", f.Data))
+			assert.NoError(ct, err)
+
+			event, err := serializer.UnmarshalEvent(eventstore.Record{EventType: f.EventType, Data: data})
+			assert.NoError(ct, err)
+			message := event.(proto.Message)
+			assert.Equal(ct, f.Message, string(message.ProtoReflect().Descriptor().FullName()))
+
+			expected := message.ProtoReflect().New().Interface()
+			assert.NoError(ct, protojson.Unmarshal(f.Fields, expected))
+			assert.True(ct, proto.Equal(expected, message))
+
+			// The serializer writes the same bytes back
+			record, err := serializer.MarshalEvent(event)
+			assert.NoError(ct, err)
+			assert.Equal(ct, data, record.Data)
+		})
+	}
+}
+
+// todo is an aggregate built from the protobuf events
+type todo struct {
+	ID      string
+	Desc    string
+	Done    bool
+	Version int
+}
+
+func (t *todo) On(e eventsourcing.Event) error {
+	switch ev := e.(type) {
+	case *testpb.TodoCreated:
+		t.Desc = ev.Desc
+	case *testpb.TodoDone:
+		t.Done = true
+	default:
+		return fmt.Errorf("unable to handle event %v", ev)
+	}
+	t.ID = e.AggregateID()
+	t.Version = e.EventVersion()
+	return nil
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := eventsourcing.NewRepository(
+		reflect.TypeOf(todo{}),
+		eventstore.GetLocalStore(),
+		NewSerializer(&testpb.TodoCreated{}, &testpb.TodoDone{}),
+		nil,
+	)
+
+	id := uuid.NewV4().String()
+	err := repo.Save(ctx,
+		&testpb.TodoCreated{Id: id, Version: 1, At: time.Now().UnixNano(), Desc: "Do this"},
+		&testpb.TodoDone{Id: id, Version: 2, At: time.Now().UnixNano()},
+	)
+	assert.NoError(t, err)
+
+	agg, err := repo.Load(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, &todo{ID: id, Desc: "Do this", Done: true, Version: 2}, agg)
+}
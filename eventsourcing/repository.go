@@ -5,32 +5,109 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"github.com/cannahum/eventsourcing-lite/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	observerCountKey = attribute.Key("eventsourcing.observer_count")
+	retryCountKey    = attribute.Key("eventsourcing.retry_count")
 )
 
 // Repository is an object that knows how to serialize a specific type of entity.
 // It also keeps a reference to the store associated with this entity.
 type Repository struct {
-	prototype  reflect.Type
-	store      eventstore.EventStore
-	serializer Serializer
-	observers  []Observer
+	factory        func() Aggregate
+	aggregateType  string
+	store          eventstore.EventStore
+	serializer     Serializer
+	observers      []Observer
+	snapshots      eventstore.SnapshotStore
+	snapshotPolicy SnapshotPolicy
+	tracer         trace.Tracer
+	backend        string
+	metrics        metrics.Recorder
+	cache          *aggregateCache
+	retry          *RetryPolicy
+	dispatcher     *dispatcher
+	deadLetters    eventstore.DeadLetterStore
+	duplicateName  error
+}
+
+// Option configures optional behavior of a Repository
+type Option func(*Repository)
+
+// loaded is an aggregate along with the version it was loaded at, the newest snapshot known, if any,
+// and the generation of the cache it was loaded in
+type loaded struct {
+	aggregate  Aggregate
+	version    int
+	snapshot   *eventstore.Snapshot
+	generation uint64
 }
 
-// Load retrieves the specified aggregate from the underlying store
+// Load retrieves the specified aggregate from the underlying store.
+// It fails with ErrAggregateNotFound when the store holds no events of the aggregate.
+// With snapshots enabled, it starts from the newest snapshot and replays only the events that followed it.
+// With a cache, it starts from the cached state instead, when there is one.
 func (r *Repository) Load(ctx context.Context, aggregateID string) (Aggregate, error) {
-	history, err := r.store.Load(ctx, aggregateID, 0, 0)
+	state, err := r.loadState(ctx, aggregateID)
 	if err != nil {
 		return nil, err
 	}
+	return state.aggregate, nil
+}
 
-	entryCount := len(history)
-	if entryCount == 0 {
-		return nil, fmt.Errorf("unable to find aggregate for id %s", aggregateID)
+// loadState is Load, returning the version the aggregate was loaded at along with it
+func (r *Repository) loadState(ctx context.Context, aggregateID string) (*loaded, error) {
+	ctx, span := r.startSpan(ctx, "Repository.Load", eventstore.AggregateIDKey.String(aggregateID))
+	state, err := r.load(ctx, aggregateID)
+	if errors.Is(err, ErrAggregateNotFound) {
+		// Loading an aggregate that does not exist yet is how a new one starts, not a failure
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.remember(aggregateID, state)
+	return state, nil
+}
+
+func (r *Repository) load(ctx context.Context, aggregateID string) (*loaded, error) {
+	state := r.cached(aggregateID)
+	if state.aggregate == nil {
+		aggregate, snapshot, err := r.restore(ctx, aggregateID)
+		if err != nil {
+			return nil, err
+		}
+		state.aggregate = aggregate
+		state.snapshot = snapshot
+		if snapshot != nil {
+			state.version = snapshot.Version
+		}
+	}
+	fromVersion := 0
+	if state.version > 0 {
+		fromVersion = state.version + 1
+	}
+	aggregate := state.aggregate
+
+	history, err := r.store.Load(ctx, aggregateID, fromVersion, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	aggregate := r.newPrototype()
+	entryCount := len(history)
+	if entryCount == 0 && state.version == 0 {
+		// Stores report missing aggregates with ErrAggregateNotFound, though custom ones may return no history
+		return nil, fmt.Errorf("%w: %s", ErrAggregateNotFound, aggregateID)
+	}
 
 	for _, record := range history {
 		event, serializerErr := r.serializer.UnmarshalEvent(record)
@@ -43,81 +120,219 @@ func (r *Repository) Load(ctx context.Context, aggregateID string) (Aggregate, e
 			eventType, _ := event.EventType()
 			return nil, fmt.Errorf("aggregate was unable to handle event, %v: %s", eventType, aggregationErr.Error())
 		}
+		state.version = record.Version
 	}
-	return aggregate, nil
+	// A snapshot or a cached aggregate saves replaying most of the history, it is measured all the same
+	r.metrics.HistoryLoaded(r.aggregateType, state.version)
+	return state, nil
 }
 
+// precondition is what Apply, Create and Update expect of the aggregate before handling a command
+type precondition int
+
+const (
+	// mayExist handles the command on the aggregate, or on a new one when it does not exist
+	mayExist precondition = iota
+	// mustNotExist handles the command on a new aggregate
+	mustNotExist
+	// mustExist handles the command on an existing aggregate
+	mustExist
+)
+
 // Apply creates new event(s) as a result of a command.
+// Events of the aggregate that are StampableEvents get their versions and time from the Repository; others must
+// carry their version already, see ErrUnstampedEvent.
+// With WithConflictRetry, a command whose events lost a race with another writer is handled again.
+// The command is handled by the aggregate, or by a new one when it does not exist yet.
+// Failing to load the aggregate fails the command rather than starting over with a new aggregate.
 func (r *Repository) Apply(ctx context.Context, command Command) (Aggregate, error) {
+	return r.handleCommand(ctx, "Repository.Apply", command, mayExist)
+}
+
+// Create creates new event(s) as a result of a command that starts an aggregate.
+// It fails with ErrAggregateExists when the aggregate already has events.
+func (r *Repository) Create(ctx context.Context, command Command) (Aggregate, error) {
+	return r.handleCommand(ctx, "Repository.Create", command, mustNotExist)
+}
+
+// Update creates new event(s) as a result of a command to an existing aggregate.
+// It fails with ErrAggregateNotFound when the aggregate has no events.
+func (r *Repository) Update(ctx context.Context, command Command) (Aggregate, error) {
+	return r.handleCommand(ctx, "Repository.Update", command, mustExist)
+}
+
+func (r *Repository) handleCommand(ctx context.Context, name string, command Command, pre precondition) (Aggregate, error) {
 	if command == nil {
-		return nil, errors.New("command provided to Repository.Apply may not be nil")
+		return nil, fmt.Errorf("command provided to %s may not be nil", name)
 	}
 	aggregateID := command.AggregateID()
 	if aggregateID == "" {
-		return nil, errors.New("command provided to Repository.Apply may not contain a blank AggregateID")
+		return nil, fmt.Errorf("command provided to %s may not contain a blank AggregateID", name)
 	}
 
-	aggregate, err := r.Load(ctx, aggregateID)
-	if err != nil {
-		aggregate = r.newPrototype()
+	start := time.Now()
+	ctx, span := r.startSpan(ctx, name, eventstore.AggregateIDKey.String(aggregateID))
+	aggregate, result, err := r.apply(ctx, span, aggregateID, command, pre)
+	for attempts := 1; result == metrics.OutcomeConflict && r.retry.allows(attempts, command); attempts++ {
+		if !r.retry.wait(ctx, attempts) {
+			break
+		}
+		r.metrics.CommandRetried(typeName(command))
+		span.SetAttributes(retryCountKey.Int(attempts))
+		aggregate, result, err = r.apply(ctx, span, aggregateID, command, pre)
+	}
+	endSpan(span, err)
+	r.metrics.CommandHandled(typeName(command), result, time.Since(start))
+	return aggregate, err
+}
+
+func (r *Repository) apply(
+	ctx context.Context,
+	span trace.Span,
+	aggregateID string,
+	command Command,
+	pre precondition,
+) (Aggregate, metrics.Outcome, error) {
+	current, err := r.loadState(ctx, aggregateID)
+	switch {
+	case err == nil && pre == mustNotExist:
+		return nil, metrics.OutcomeRejected, fmt.Errorf("%w: %s", ErrAggregateExists, aggregateID)
+	case errors.Is(err, ErrAggregateNotFound) && pre != mustExist:
+		current = &loaded{aggregate: r.newPrototype()}
+	case errors.Is(err, ErrAggregateNotFound):
+		return nil, metrics.OutcomeRejected, err
+	case err != nil:
+		return nil, metrics.OutcomeError, err
 	}
 
-	h, ok := aggregate.(CommandHandler)
+	h, ok := current.aggregate.(CommandHandler)
 	if !ok {
-		return nil, fmt.Errorf("aggregate, %v, does not implement CommandHandler", aggregate)
+		return nil, metrics.OutcomeError, fmt.Errorf("aggregate, %v, does not implement CommandHandler", current.aggregate)
 	}
 
-	events, err := h.Apply(ctx, command)
+	handleCtx, handleSpan := r.startSpan(ctx, "Repository.Handle", eventstore.AggregateIDKey.String(aggregateID))
+	events, err := h.Apply(handleCtx, command)
+	handleSpan.SetAttributes(eventAttributes(events)...)
+	endSpan(handleSpan, err)
 	if err != nil {
-		return nil, err
+		return nil, metrics.OutcomeRejected, err
+	}
+	if err = stampEvents(aggregateID, current.version, time.Now(), events); err != nil {
+		return nil, metrics.OutcomeError, err
 	}
+	span.SetAttributes(eventAttributes(events)...)
 
-	err = r.Save(ctx, events...)
+	// With WithAsyncObservers, the events are queued for observers before any later command on the aggregate
+	// saves events, so they are observed in the order they were saved
+	defer r.dispatcher.sequence(aggregateID)()
+
+	records, err := r.saveEvents(ctx, events)
 	if err != nil {
-		return nil, err
+		return nil, outcome(err), err
 	}
 
-	var reloaded Aggregate
-	reloaded, err = r.Load(ctx, aggregateID)
+	reloadCtx, reloadSpan := r.startSpan(ctx, "Repository.Reload", eventstore.AggregateIDKey.String(aggregateID))
+	state, err := r.load(reloadCtx, aggregateID)
+	if err == nil {
+		r.takeSnapshot(reloadCtx, aggregateID, state)
+		r.remember(aggregateID, state)
+	}
+	endSpan(reloadSpan, err)
 	if err != nil {
-		return nil, err
+		return nil, outcome(err), err
 	}
+	reloaded := state.aggregate
+
+	r.notify(ctx, aggregateID, reloaded, events, records)
+
+	return reloaded, metrics.OutcomeSuccess, nil
+}
 
-	lastEvent := events[len(events)-1]
-	for _, observer := range r.observers {
-		if observer.WillObserve(ctx, reloaded, lastEvent) {
-			err = observer.Observe(ctx, reloaded, lastEvent)
-			if err != nil {
-				observer.OnObserveFailed(ctx, err)
-			}
+// stampEvents numbers the events of an aggregate at version in order, and dates them at. Events that are not
+// StampableEvents keep their own version and time, so they must already be at the version that is theirs in order.
+func stampEvents(aggregateID string, version int, at time.Time, events []Event) error {
+	for _, event := range events {
+		if event.AggregateID() != aggregateID {
+			continue
+		}
+		version++
+		if stampable, ok := event.(StampableEvent); ok {
+			stampable.StampEvent(version, at)
+		} else if event.EventVersion() != version {
+			_, eventType := event.EventType()
+			return fmt.Errorf("%w: %s of %s is at version %d rather than %d, emit a pointer to it to have it stamped",
+				ErrUnstampedEvent, eventType, aggregateID, event.EventVersion(), version)
 		}
 	}
-
-	return reloaded, nil
+	return nil
 }
 
-// Save persists the events into the underlying Store
+// Save persists the events into the underlying Store.
+// The events must continue their aggregate's stream; the version preceding an aggregate's first event is the
+// version the store is expected to be at, so a concurrent writer causes an *eventstore.ErrConcurrencyConflict.
+// Events of several aggregates are saved atomically, which takes a store that implements
+// eventstore.MultiStreamSaver.
+// Every record gets a new event id, along with the correlation id, causation id and headers set on ctx.
 func (r *Repository) Save(ctx context.Context, events ...Event) error {
+	_, err := r.saveEvents(ctx, events)
+	return err
+}
+
+// saveEvents is Save, returning the records the events were saved as, in the same order
+func (r *Repository) saveEvents(ctx context.Context, events []Event) ([]eventstore.Record, error) {
 	if len(events) == 0 {
-		return nil
+		return nil, nil
 	}
-	aggregateID := events[0].AggregateID()
 
-	history := make(eventstore.History, 0, len(events))
+	ctx, span := r.startSpan(ctx, "Repository.Save", eventAttributes(events)...)
+	records, err := r.save(ctx, span, events)
+	endSpan(span, err)
+	return records, err
+}
+
+func (r *Repository) save(ctx context.Context, span trace.Span, events []Event) ([]eventstore.Record, error) {
+	var appends []eventstore.Append
+	records := make([]eventstore.Record, 0, len(events))
+	streamIndex := map[string]int{}
 	for _, event := range events {
 		record, err := r.serializer.MarshalEvent(event)
 		if err != nil {
-			return fmt.Errorf("could not marshal json from event %v", event)
+			return nil, fmt.Errorf("could not marshal json from event %v", event)
+		}
+		stamp(ctx, &record)
+		records = append(records, record)
+
+		aggregateID := event.AggregateID()
+		i, ok := streamIndex[aggregateID]
+		if !ok {
+			i = len(appends)
+			streamIndex[aggregateID] = i
+			appends = append(appends, eventstore.Append{AggregateID: aggregateID, ExpectedVersion: event.EventVersion() - 1})
+		}
+		if v := event.EventVersion() - 1; v < appends[i].ExpectedVersion {
+			appends[i].ExpectedVersion = v
 		}
-		history = append(history, record)
+		appends[i].Records = append(appends[i].Records, record)
+	}
+
+	if len(appends) == 1 {
+		span.SetAttributes(eventstore.AggregateIDKey.String(appends[0].AggregateID))
+		return records, r.store.Save(ctx, appends[0].AggregateID, appends[0].ExpectedVersion, appends[0].Records...)
+	}
+	aggregateIDs := make([]string, 0, len(appends))
+	for _, a := range appends {
+		aggregateIDs = append(aggregateIDs, a.AggregateID)
 	}
-	return r.store.Save(ctx, aggregateID, history...)
+	span.SetAttributes(eventstore.AggregateIDKey.StringSlice(aggregateIDs))
+	saver, ok := r.store.(eventstore.MultiStreamSaver)
+	if !ok {
+		return nil, errors.New("the store cannot save events of several aggregates at once")
+	}
+	return records, saver.SaveAll(ctx, appends...)
 }
 
 func (r *Repository) newPrototype() Aggregate {
-	rNew := reflect.New(r.prototype)
-	rIf := rNew.Interface()
-	return rIf.(Aggregate)
+	return r.factory()
 }
 
 // NewRepository is a factory function that creates a new Repository object
@@ -126,6 +341,40 @@ func NewRepository(
 	store eventstore.EventStore,
 	serializer Serializer,
 	observers []Observer,
+	opts ...Option,
+) *Repository {
+	factory := func() Aggregate {
+		return reflect.New(t).Interface().(Aggregate)
+	}
+	return newRepository(factory, t.Name(), store, serializer, observers, opts)
+}
+
+func newRepository(
+	factory func() Aggregate,
+	aggregateType string,
+	store eventstore.EventStore,
+	serializer Serializer,
+	observers []Observer,
+	opts []Option,
 ) *Repository {
-	return &Repository{t, store, serializer, observers}
+	r := &Repository{
+		factory:       factory,
+		aggregateType: aggregateType,
+		store:         store,
+		serializer:    serializer,
+		observers:     observers,
+		tracer:        trace.NewNoopTracerProvider().Tracer(eventstore.TracerName),
+		backend:       eventstore.Backend(store),
+		metrics:       metrics.Nop{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.deadLetters != nil {
+		r.duplicateName = uniqueObserverNames(observers)
+	}
+	if r.dispatcher != nil {
+		r.dispatcher.start(r)
+	}
+	return r
 }
@@ -2,17 +2,23 @@ package eventsourcing
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 
 	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"github.com/cannahum/eventsourcing-lite/metrics"
 	"github.com/cannahum/eventsourcing-lite/utils/testutils"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const tableNamePrfx = "todo_es_table_test_"
@@ -192,7 +198,8 @@ func TestSave(t *testing.T) {
 
 			todoCreatedEvent := TodoCreated{
 				Model: Model{
-					ID: id,
+					ID:      id,
+					Version: 1,
 				},
 				Desc: "Do this",
 			}
@@ -245,6 +252,79 @@ func TestSave(t *testing.T) {
 
 			assert.Equal(ct, expectedEvents, actualEvents)
 		})
+
+		t.Run("saving with metadata", func(ct *testing.T) {
+			var id = uuid.NewV4().String()
+			at := time.Now()
+			todoCreatedEvent := TodoCreated{
+				Model: Model{
+					ID:      id,
+					Version: 1,
+					At:      at,
+				},
+				Desc: "Do this",
+			}
+
+			metaCtx := ContextWithCorrelationID(ctx, "request-1")
+			metaCtx = ContextWithCausationID(metaCtx, "command-1")
+			metaCtx = ContextWithHeaders(metaCtx, map[string]string{"tenant": "acme"})
+			err := repo.Save(metaCtx, todoCreatedEvent)
+			assert.NoError(ct, err)
+
+			history, _ := repo.store.Load(ctx, id, 0, 0)
+			assert.Len(ct, history, 1)
+			record := history[0]
+			assert.Equal(ct, "TodoCreated", record.EventType)
+			assert.True(ct, at.Equal(record.OccurredAt))
+			assert.NotEmpty(ct, record.EventID)
+			assert.Equal(ct, "request-1", record.CorrelationID)
+			assert.Equal(ct, "command-1", record.CausationID)
+			assert.Equal(ct, map[string]string{"tenant": "acme"}, record.Headers)
+		})
+
+		t.Run("saving events of two aggregates at once", func(ct *testing.T) {
+			var first, second = uuid.NewV4().String(), uuid.NewV4().String()
+			_ = repo.Save(ctx, TodoCreated{Model: Model{ID: first, Version: 1}, Desc: "Do this"})
+
+			err := repo.Save(ctx,
+				TodoDone{Model: Model{ID: first, Version: 2}},
+				TodoCreated{Model: Model{ID: second, Version: 1}, Desc: "Do that"},
+			)
+			assert.NoError(ct, err)
+			history, _ := repo.store.Load(ctx, first, 0, 0)
+			assert.Len(ct, history, 2)
+			history, _ = repo.store.Load(ctx, second, 0, 0)
+			assert.Len(ct, history, 1)
+
+			// One stale aggregate keeps the other from being saved
+			err = repo.Save(ctx,
+				TodoUndone{Model: Model{ID: first, Version: 3}},
+				TodoCreated{Model: Model{ID: second, Version: 1}, Desc: "Do that again"},
+			)
+			var conflict *eventstore.ErrConcurrencyConflict
+			assert.True(ct, errors.As(err, &conflict))
+			history, _ = repo.store.Load(ctx, first, 0, 0)
+			assert.Len(ct, history, 2)
+		})
+
+		t.Run("saving over an existing version (error)", func(ct *testing.T) {
+			var id = uuid.NewV4().String()
+			todoCreatedEvent := TodoCreated{
+				Model: Model{
+					ID:      id,
+					Version: 1,
+				},
+				Desc: "Do that",
+			}
+			err := repo.Save(ctx, todoCreatedEvent)
+			assert.NoError(ct, err)
+
+			err = repo.Save(ctx, todoCreatedEvent)
+			var conflict *eventstore.ErrConcurrencyConflict
+			assert.True(ct, errors.As(err, &conflict))
+			assert.Equal(ct, 0, conflict.ExpectedVersion)
+			assert.Equal(ct, 1, conflict.ActualVersion)
+		})
 	}
 }
 
@@ -264,7 +344,7 @@ func TestLoad(t *testing.T) {
 		t.Run("non-existent aggregate", func(ct *testing.T) {
 			r, err := repo.Load(ctx, "some-id")
 			assert.Nil(ct, r)
-			assert.Error(ct, err)
+			assert.ErrorIs(ct, err, ErrAggregateNotFound)
 		})
 
 		t.Run("existent aggregate with multiple events", func(ct *testing.T) {
@@ -517,5 +597,451 @@ func TestApply(t *testing.T) {
 			assert.Error(ct, err)
 			assert.Nil(ct, returned)
 		})
+
+		t.Run("concurrent commands on one aggregate (only one succeeds)", func(ct *testing.T) {
+			var id = uuid.NewV4().String()
+
+			_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this once"})
+			assert.NoError(ct, err)
+
+			const writers = 8
+			var wg sync.WaitGroup
+			results := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, applyErr := repo.Apply(ctx, &MarkDone{CommandModel{id}})
+					results <- applyErr
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			succeeded := 0
+			for applyErr := range results {
+				if applyErr == nil {
+					succeeded++
+				}
+			}
+			assert.Equal(ct, 1, succeeded)
+
+			history, _ := repo.store.Load(ctx, id, 0, 0)
+			assert.Len(ct, history, 2)
+		})
 	}
 }
+
+// loadRecorder is an EventStore that remembers the version each Load started from
+type loadRecorder struct {
+	eventstore.EventStore
+	fromVersions []int
+}
+
+func (l *loadRecorder) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (eventstore.History, error) {
+	l.fromVersions = append(l.fromVersions, fromVersion)
+	return l.EventStore.Load(ctx, aggregateID, fromVersion, toVersion)
+}
+
+// unavailableStore is an EventStore whose Load fails as if the database could not be reached
+type unavailableStore struct {
+	eventstore.EventStore
+}
+
+func (u unavailableStore) Load(context.Context, string, int, int) (eventstore.History, error) {
+	return nil, errors.New("request timed out")
+}
+
+func TestCreateAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	store := eventstore.GetLocalStore()
+	repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil)
+
+	t.Run("Create starts an aggregate", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		r, err := repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Equal(ct, 1, r.(*MyTodo).Version)
+	})
+
+	t.Run("Create of an existing aggregate (error)", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		_, err := repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		r, err := repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do that"})
+		assert.Nil(ct, r)
+		assert.ErrorIs(ct, err, ErrAggregateExists)
+
+		history, _ := store.Load(ctx, id, 0, 0)
+		assert.Len(ct, history, 1)
+	})
+
+	t.Run("Update changes an existing aggregate", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		_, err := repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		r, err := repo.Update(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		assert.True(ct, r.(*MyTodo).Done)
+		assert.Equal(ct, 2, r.(*MyTodo).Version)
+	})
+
+	t.Run("Update of a non-existent aggregate (error)", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		r, err := repo.Update(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.Nil(ct, r)
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+
+		_, err = store.Load(ctx, id, 0, 0)
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+	})
+
+	t.Run("nil command names the method (error)", func(ct *testing.T) {
+		_, err := repo.Update(ctx, nil)
+		assert.EqualError(ct, err, "command provided to Repository.Update may not be nil")
+	})
+
+	t.Run("a failing store is not a new aggregate (error)", func(ct *testing.T) {
+		failing := NewRepository(reflect.TypeOf(MyTodo{}), unavailableStore{store}, serializer, nil)
+		var id = uuid.NewV4().String()
+		for _, handle := range []func(context.Context, Command) (Aggregate, error){failing.Apply, failing.Create, failing.Update} {
+			r, err := handle(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+			assert.Nil(ct, r)
+			assert.EqualError(ct, err, "request timed out")
+		}
+
+		_, err := store.Load(ctx, id, 0, 0)
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+	})
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+
+	t.Run("Load starts after the newest snapshot", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		snapshots := eventstore.GetLocalSnapshotStore()
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithSnapshots(snapshots, SnapshotEvery(2)))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		snapshot, _ := snapshots.LoadSnapshot(ctx, id)
+		assert.Nil(ct, snapshot)
+
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		snapshot, _ = snapshots.LoadSnapshot(ctx, id)
+		assert.NotNil(ct, snapshot)
+		assert.Equal(ct, 2, snapshot.Version)
+
+		_, err = repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		store.fromVersions = nil
+		agg, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, []int{3}, store.fromVersions)
+
+		todo := agg.(*MyTodo)
+		assert.Equal(ct, "Do this", todo.Desc)
+		assert.False(ct, todo.Done)
+		assert.Equal(ct, 3, todo.Version)
+	})
+
+	t.Run("Load from a snapshot with no events after it", func(ct *testing.T) {
+		snapshots := eventstore.GetLocalSnapshotStore()
+		repo := NewRepository(
+			reflect.TypeOf(MyTodo{}),
+			eventstore.GetLocalStore(),
+			serializer,
+			nil,
+			WithSnapshots(snapshots, SnapshotEvery(1)),
+		)
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		agg, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, 1, agg.(*MyTodo).Version)
+	})
+
+	t.Run("an unreadable snapshot is ignored", func(ct *testing.T) {
+		store := &loadRecorder{EventStore: eventstore.GetLocalStore()}
+		snapshots := eventstore.GetLocalSnapshotStore()
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithSnapshots(snapshots, SnapshotEvery(100)))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_ = snapshots.SaveSnapshot(ctx, eventstore.Snapshot{AggregateID: id, Version: 1, Data: []byte("not json")})
+
+		store.fromVersions = nil
+		agg, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, []int{0}, store.fromVersions)
+		assert.Equal(ct, "Do this", agg.(*MyTodo).Desc)
+	})
+}
+
+func TestSnapshotPolicies(t *testing.T) {
+	t.Run("SnapshotEvery", func(ct *testing.T) {
+		policy := SnapshotEvery(10)
+		assert.False(ct, policy(9, time.Hour))
+		assert.True(ct, policy(10, 0))
+	})
+
+	t.Run("SnapshotAfter", func(ct *testing.T) {
+		policy := SnapshotAfter(time.Minute)
+		assert.False(ct, policy(5, time.Second))
+		assert.False(ct, policy(0, time.Hour))
+		assert.True(ct, policy(1, time.Hour))
+	})
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := eventstore.GetTracingStore(eventstore.GetLocalStore(), tp)
+	repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, WithTracing(tp))
+
+	t.Run("Apply makes a span for each phase", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		var names []string
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+			names = append(names, span.Name())
+		}
+		assert.Equal(ct, []string{
+			"EventStore.Load",
+			"Repository.Load",
+			"Repository.Handle",
+			"EventStore.Save",
+			"Repository.Save",
+			"EventStore.Load",
+			"Repository.Reload",
+			"Repository.Observe",
+			"Repository.Apply",
+		}, names)
+
+		apply := spans["Repository.Apply"]
+		for _, name := range []string{"Repository.Load", "Repository.Handle", "Repository.Save", "Repository.Reload", "Repository.Observe"} {
+			assert.Equal(ct, apply.SpanContext().SpanID(), spans[name].Parent().SpanID())
+		}
+		assert.Equal(ct, spans["Repository.Save"].SpanContext().SpanID(), spans["EventStore.Save"].Parent().SpanID())
+
+		attrs := map[string]interface{}{}
+		for _, kv := range apply.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		assert.Equal(ct, id, attrs[string(eventstore.AggregateIDKey)])
+		assert.Equal(ct, int64(1), attrs[string(eventstore.EventCountKey)])
+		assert.Equal(ct, []string{"TodoCreated"}, attrs[string(eventstore.EventTypesKey)])
+		assert.Equal(ct, "memory", attrs[string(eventstore.BackendKey)])
+
+		// The aggregate did not exist before the command, which is not a failure
+		assert.Equal(ct, codes.Unset, spans["Repository.Load"].Status().Code)
+		assert.Equal(ct, codes.Unset, apply.Status().Code)
+	})
+
+	t.Run("a failed command marks the Apply span", func(ct *testing.T) {
+		_, err := repo.Apply(ctx, &DoUnknown{CommandModel: CommandModel{ID: uuid.NewV4().String()}})
+		assert.Error(ct, err)
+
+		spans := recorder.Ended()
+		assert.Equal(ct, "Repository.Apply", spans[len(spans)-1].Name())
+		assert.Equal(ct, codes.Error, spans[len(spans)-1].Status().Code)
+	})
+}
+
+// metricsRecorder is a metrics.Recorder that remembers what the Repository measured
+type metricsRecorder struct {
+	metrics.Nop
+	commands         []string
+	histories        []string
+	observerFailures []string
+}
+
+func (m *metricsRecorder) CommandHandled(commandType string, outcome metrics.Outcome, _ time.Duration) {
+	m.commands = append(m.commands, commandType+"/"+string(outcome))
+}
+
+func (m *metricsRecorder) HistoryLoaded(aggregateType string, events int) {
+	m.histories = append(m.histories, fmt.Sprintf("%s/%d", aggregateType, events))
+}
+
+func (m *metricsRecorder) ObserverFailed(observer string) {
+	m.observerFailures = append(m.observerFailures, observer)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	recorder := &metricsRecorder{}
+	observer := &MyTodoFailingObserver{howManyTimes: 3}
+	repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, []Observer{observer}, WithMetrics(recorder))
+
+	var id = uuid.NewV4().String()
+	_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+	assert.NoError(t, err)
+	_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+	assert.NoError(t, err)
+	_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+	assert.Error(t, err)
+
+	t.Run("commands are measured by type and outcome", func(ct *testing.T) {
+		assert.Equal(ct, []string{"CreateTodo/success", "MarkDone/success", "MarkDone/rejected"}, recorder.commands)
+	})
+
+	t.Run("every load measures the history of the aggregate", func(ct *testing.T) {
+		// Each Apply loads before handling the command and reloads after saving its events
+		assert.Equal(ct, []string{"MyTodo/1", "MyTodo/1", "MyTodo/2", "MyTodo/2"}, recorder.histories)
+	})
+
+	t.Run("an aggregate loaded from a snapshot measures its whole history", func(ct *testing.T) {
+		recorder := &metricsRecorder{}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil,
+			WithSnapshots(eventstore.GetLocalSnapshotStore(), SnapshotEvery(2)), WithMetrics(recorder))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		_, err = repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "MyTodo/2", recorder.histories[len(recorder.histories)-1])
+	})
+
+	t.Run("a cached aggregate measures its whole history", func(ct *testing.T) {
+		recorder := &metricsRecorder{}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), eventstore.GetLocalStore(), serializer, nil,
+			WithCache(10, 0), WithMetrics(recorder))
+
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		_, err = repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "MyTodo/2", recorder.histories[len(recorder.histories)-1])
+	})
+
+	t.Run("observer failures are measured", func(ct *testing.T) {
+		assert.Equal(ct, []string{"MyTodoFailingObserver", "MyTodoFailingObserver"}, recorder.observerFailures)
+	})
+
+	t.Run("a conflict is an outcome of its own", func(ct *testing.T) {
+		err := repo.Save(ctx, &TodoDone{Model: Model{ID: id, Version: 2}})
+		assert.Error(ct, err)
+		assert.Equal(ct, metrics.OutcomeConflict, outcome(err))
+	})
+}
+
+// stampedTodo is a MyTodo whose command handler leaves versions and times to the Repository
+type stampedTodo struct {
+	MyTodo
+}
+
+func (t *stampedTodo) Apply(_ context.Context, command Command) ([]Event, error) {
+	switch v := command.(type) {
+	case *CreateTodo:
+		// Created and done at once
+		return []Event{
+			&TodoCreated{Model: Model{ID: v.ID}, Desc: v.Desc},
+			&TodoDone{Model: Model{ID: v.ID}},
+		}, nil
+	case *MarkUndone:
+		return []Event{&TodoUndone{Model: Model{ID: v.ID}}}, nil
+	case *MarkDone:
+		// Values cannot be stamped, so they keep the version they were given
+		return []Event{TodoDone{Model: Model{ID: v.ID, Version: t.Version + 1, At: time.Now()}}}, nil
+	}
+	return nil, fmt.Errorf("unhandled command, %v", command)
+}
+
+// unstampedTodo is a MyTodo whose command handler emits an event value without a version
+type unstampedTodo struct {
+	MyTodo
+}
+
+func (t *unstampedTodo) Apply(ctx context.Context, command Command) ([]Event, error) {
+	if v, ok := command.(*MarkDone); ok {
+		return []Event{TodoDone{Model: Model{ID: v.ID}}}, nil
+	}
+	return t.MyTodo.Apply(ctx, command)
+}
+
+func TestStampedEvents(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	store := eventstore.GetLocalStore()
+	repo := NewTypedRepository(func() *stampedTodo { return &stampedTodo{} }, store, serializer, nil)
+
+	var id = uuid.NewV4().String()
+	before := time.Now()
+	todo, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+	assert.NoError(t, err)
+
+	t.Run("events are numbered from the aggregate's version", func(ct *testing.T) {
+		assert.Equal(ct, 2, todo.Version)
+		assert.True(ct, todo.Done)
+
+		todo, err = repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+		assert.NoError(ct, err)
+		assert.Equal(ct, 3, todo.Version)
+		assert.False(ct, todo.Done)
+	})
+
+	t.Run("events are dated when the command is handled", func(ct *testing.T) {
+		history, _ := store.Load(ctx, id, 0, 0)
+		assert.Len(ct, history, 3)
+		assert.Equal(ct, history[0].OccurredAt, history[1].OccurredAt)
+		assert.False(ct, history[0].OccurredAt.Before(before))
+		assert.False(ct, history[2].OccurredAt.Before(history[1].OccurredAt))
+	})
+
+	t.Run("events that are not stampable keep their version", func(ct *testing.T) {
+		todo, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		assert.Equal(ct, 4, todo.Version)
+	})
+
+	t.Run("events that are not stampable and have the wrong version are rejected (error)", func(ct *testing.T) {
+		repo := NewTypedRepository(func() *unstampedTodo { return &unstampedTodo{} }, store, serializer, nil)
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+
+		_, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.ErrorIs(ct, err, ErrUnstampedEvent)
+		history, _ := store.Load(ctx, id, 0, 0)
+		assert.Len(ct, history, 1)
+	})
+
+	t.Run("Model is stampable through a pointer", func(ct *testing.T) {
+		var event Event = &TodoDone{}
+		stampable, ok := event.(StampableEvent)
+		assert.True(ct, ok)
+		at := time.Now()
+		stampable.StampEvent(7, at)
+		assert.Equal(ct, 7, event.EventVersion())
+		assert.Equal(ct, at, event.EventAt())
+
+		_, ok = Event(TodoDone{}).(StampableEvent)
+		assert.False(ct, ok)
+	})
+}
@@ -0,0 +1,88 @@
+package eventsourcing
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is how Apply, Create and Update retry a command whose events lost a race with another writer of
+// the aggregate. A retry loads the aggregate again, has it handle the command again and saves the new events.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a command is handled at most, the first time included
+	MaxAttempts int
+
+	// BaseDelay is the longest wait before the first retry. It doubles with every retry, up to MaxDelay, and
+	// each wait is a random duration up to it, so writers that raced do not race again.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the longest wait before a retry; 0 leaves it uncapped
+	MaxDelay time.Duration
+
+	// Retryable says whether a command is safe to handle again; nil retries every command
+	Retryable func(Command) bool
+}
+
+// DefaultRetryPolicy handles a command up to three times, waiting up to 10ms before the first retry
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// WithConflictRetry makes the Repository retry commands that fail with an *eventstore.ErrConcurrencyConflict,
+// as policy says. Every retry is counted by the metrics.Recorder of the Repository.
+func WithConflictRetry(policy RetryPolicy) Option {
+	return func(r *Repository) {
+		r.retry = &policy
+	}
+}
+
+// allows reports whether a command that was handled attempts times may be handled again
+func (p *RetryPolicy) allows(attempts int, command Command) bool {
+	if p == nil || attempts >= p.MaxAttempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(command)
+}
+
+// do calls f until it succeeds, or as many times as MaxAttempts allows, waiting in between; a nil policy calls
+// f once. It returns how many times it called f along with the error of the last call.
+func (p *RetryPolicy) do(ctx context.Context, f func() error) (int, error) {
+	attempts := 1
+	err := f()
+	for ; err != nil && p != nil && attempts < p.MaxAttempts; attempts++ {
+		if !p.wait(ctx, attempts) {
+			break
+		}
+		err = f()
+	}
+	return attempts, err
+}
+
+// wait sleeps before the retry that follows attempts, and reports false when ctx ended first
+func (p *RetryPolicy) wait(ctx context.Context, attempts int) bool {
+	d := p.BaseDelay
+	for i := 1; i < attempts && d < time.Duration(math.MaxInt64/2); i++ {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(d) + 1)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,163 @@
+package eventsourcing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// racingStore is an EventStore where another writer gets to append to a stream right before each of the
+// next races saves, so those saves conflict
+type racingStore struct {
+	eventstore.EventStore
+	serializer Serializer
+	races      int
+}
+
+func (s *racingStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...eventstore.Record) error {
+	if s.races > 0 {
+		s.races--
+		record, err := s.serializer.MarshalEvent(&TodoCreated{
+			Model: Model{ID: aggregateID, Version: expectedVersion + 1, At: time.Now()},
+			Desc:  "Do that",
+		})
+		if err != nil {
+			return err
+		}
+		if err = s.EventStore.Save(ctx, aggregateID, expectedVersion, record); err != nil {
+			return err
+		}
+	}
+	return s.EventStore.Save(ctx, aggregateID, expectedVersion, records...)
+}
+
+// retryRecorder is a metrics.Recorder that remembers the commands retried and how they ended
+type retryRecorder struct {
+	metricsRecorder
+	retried []string
+}
+
+func (m *retryRecorder) CommandRetried(commandType string) {
+	m.retried = append(m.retried, commandType)
+}
+
+func TestConflictRetry(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	setup := func(races int, opts ...Option) (*Repository, *racingStore, string) {
+		store := &racingStore{EventStore: eventstore.GetLocalStore(), serializer: serializer}
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil, opts...)
+		var id = uuid.NewV4().String()
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this first"})
+		assert.NoError(t, err)
+		store.races = races
+		return repo, store, id
+	}
+
+	t.Run("a command that lost a race is handled again", func(ct *testing.T) {
+		recorder := &retryRecorder{}
+		repo, _, id := setup(2, WithConflictRetry(policy), WithMetrics(recorder))
+
+		r, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		todo := r.(*MyTodo)
+		assert.Equal(ct, "Do this", todo.Desc)
+		// The first event, one of each race, and the command's
+		assert.Equal(ct, 4, todo.Version)
+		assert.Equal(ct, []string{"CreateTodo", "CreateTodo"}, recorder.retried)
+		assert.Equal(ct, []string{"CreateTodo/success", "CreateTodo/success"}, recorder.commands)
+	})
+
+	t.Run("retries stop after MaxAttempts (error)", func(ct *testing.T) {
+		recorder := &retryRecorder{}
+		repo, _, id := setup(3, WithConflictRetry(policy), WithMetrics(recorder))
+
+		r, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.Nil(ct, r)
+		var conflict *eventstore.ErrConcurrencyConflict
+		assert.ErrorAs(ct, err, &conflict)
+		assert.Len(ct, recorder.retried, 2)
+		assert.Equal(ct, "CreateTodo/conflict", recorder.commands[len(recorder.commands)-1])
+	})
+
+	t.Run("a command that is not retryable is handled once (error)", func(ct *testing.T) {
+		recorder := &retryRecorder{}
+		onlyDone := policy
+		onlyDone.Retryable = func(command Command) bool {
+			_, ok := command.(*MarkDone)
+			return ok
+		}
+		repo, _, id := setup(1, WithConflictRetry(onlyDone), WithMetrics(recorder))
+
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		var conflict *eventstore.ErrConcurrencyConflict
+		assert.ErrorAs(ct, err, &conflict)
+		assert.Empty(ct, recorder.retried)
+	})
+
+	t.Run("a retry handles the command against the new state (error)", func(ct *testing.T) {
+		recorder := &retryRecorder{}
+		repo, store, id := setup(0, WithConflictRetry(policy), WithMetrics(recorder))
+		_, err := repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+
+		// The other writer's TodoCreated leaves the todo undone, so the retry refuses to undo it
+		store.races = 1
+		r, err := repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+		assert.Nil(ct, r)
+		assert.EqualError(ct, err, "MyTodo "+id+" is already undone")
+		assert.Equal(ct, []string{"MarkUndone"}, recorder.retried)
+		assert.Equal(ct, "MarkUndone/rejected", recorder.commands[len(recorder.commands)-1])
+	})
+
+	t.Run("without WithConflictRetry a conflict fails the command (error)", func(ct *testing.T) {
+		repo, _, id := setup(1)
+		_, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		var conflict *eventstore.ErrConcurrencyConflict
+		assert.ErrorAs(ct, err, &conflict)
+	})
+
+	t.Run("waiting for a retry ends with the context (error)", func(ct *testing.T) {
+		slow := policy
+		slow.BaseDelay = time.Hour
+		repo, _, id := setup(1, WithConflictRetry(slow))
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := repo.Apply(timeoutCtx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		var conflict *eventstore.ErrConcurrencyConflict
+		assert.ErrorAs(ct, err, &conflict)
+		assert.Less(ct, time.Since(start), time.Minute)
+	})
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("waits are jittered up to a doubling, capped delay", func(ct *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+		for attempts := 1; attempts < 8; attempts++ {
+			start := time.Now()
+			assert.True(ct, policy.wait(ctx, attempts))
+			// Generous for slow machines, yet far below an uncapped 2^6ms
+			assert.Less(ct, time.Since(start), 50*time.Millisecond)
+		}
+	})
+
+	t.Run("no delay does not wait", func(ct *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 2}
+		assert.True(ct, policy.wait(ctx, 1))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.False(ct, policy.wait(cancelled, 1))
+	})
+}
@@ -6,7 +6,7 @@ import (
 
 // Serializer converts between Events and Records
 type Serializer interface {
-	// MarshalEvent converts an Event to a Record
+	// MarshalEvent converts an Event to a Record, filling in the record's EventType and OccurredAt
 	MarshalEvent(event Event) (eventstore.Record, error)
 
 	// UnmarshalEvent converts an Event backed into a Record
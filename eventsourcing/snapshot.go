@@ -0,0 +1,94 @@
+package eventsourcing
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+// SnapshotPolicy decides whether to take a snapshot of an aggregate, given how many events and how much time
+// went by since its newest snapshot. For an aggregate without a snapshot, events is its version and elapsed
+// is as long as a time.Duration can be.
+type SnapshotPolicy func(events int, elapsed time.Duration) bool
+
+// SnapshotEvery takes a snapshot once n events followed the newest one
+func SnapshotEvery(n int) SnapshotPolicy {
+	return func(events int, _ time.Duration) bool {
+		return events >= n
+	}
+}
+
+// SnapshotAfter takes a snapshot once the newest one is older than d and events followed it
+func SnapshotAfter(d time.Duration) SnapshotPolicy {
+	return func(events int, elapsed time.Duration) bool {
+		return events > 0 && elapsed >= d
+	}
+}
+
+// WithSnapshots makes the Repository keep snapshots of its aggregates in store, taken after Apply whenever
+// policy says so. Load then starts from the newest snapshot and replays only the events that followed it.
+// State is stored with encoding/json, so aggregates may implement json.Marshaler and json.Unmarshaler to
+// control what a snapshot holds.
+func WithSnapshots(store eventstore.SnapshotStore, policy SnapshotPolicy) Option {
+	return func(r *Repository) {
+		r.snapshots = store
+		r.snapshotPolicy = policy
+	}
+}
+
+// restore returns an aggregate at the state of its newest snapshot along with the snapshot.
+// Without a snapshot to start from, it returns a new aggregate and a nil snapshot.
+func (r *Repository) restore(ctx context.Context, aggregateID string) (Aggregate, *eventstore.Snapshot, error) {
+	if r.snapshots == nil {
+		return r.newPrototype(), nil, nil
+	}
+	snapshot, err := r.snapshots.LoadSnapshot(ctx, aggregateID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if snapshot == nil {
+		return r.newPrototype(), nil, nil
+	}
+
+	aggregate := r.newPrototype()
+	if err = json.Unmarshal(snapshot.Data, aggregate); err != nil {
+		// The aggregate's shape changed since the snapshot was taken, so every event is replayed instead
+		return r.newPrototype(), nil, nil
+	}
+	return aggregate, snapshot, nil
+}
+
+// takeSnapshot stores the state of an aggregate when the snapshot policy asks for it, and notes in state that
+// it is the newest snapshot. The events are saved by then, so a snapshot that fails only makes the next Load
+// replay more of them.
+func (r *Repository) takeSnapshot(ctx context.Context, aggregateID string, state *loaded) {
+	if r.snapshots == nil {
+		return
+	}
+
+	events, elapsed := state.version, time.Duration(math.MaxInt64)
+	if state.snapshot != nil {
+		events = state.version - state.snapshot.Version
+		elapsed = time.Since(state.snapshot.At)
+	}
+	if !r.snapshotPolicy(events, elapsed) {
+		return
+	}
+
+	data, err := json.Marshal(state.aggregate)
+	if err != nil {
+		return
+	}
+	snapshot := eventstore.Snapshot{
+		AggregateID: aggregateID,
+		Version:     state.version,
+		Data:        data,
+		At:          time.Now(),
+	}
+	if r.snapshots.SaveSnapshot(ctx, snapshot) == nil {
+		state.snapshot = &snapshot
+	}
+}
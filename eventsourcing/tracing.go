@@ -0,0 +1,52 @@
+package eventsourcing
+
+import (
+	"context"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracing makes the Repository trace Load, Save and Apply with OpenTelemetry spans, created with tp or with
+// the global TracerProvider when tp is nil. Apply has a child span for each of its phases: loading the
+// aggregate, handling the command, saving the events, reloading the aggregate and notifying the observers.
+// Wrap the store with eventstore.GetTracingStore to trace the calls to it as well.
+func WithTracing(tp trace.TracerProvider) Option {
+	return func(r *Repository) {
+		if tp == nil {
+			tp = otel.GetTracerProvider()
+		}
+		r.tracer = tp.Tracer(eventstore.TracerName)
+	}
+}
+
+// startSpan starts a span with the attributes, along with the store backend
+func (r *Repository) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, eventstore.BackendKey.String(r.backend))
+	return r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// eventAttributes describes events by their count and types
+func eventAttributes(events []Event) []attribute.KeyValue {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		_, eventType := event.EventType()
+		types = append(types, eventType)
+	}
+	return []attribute.KeyValue{
+		eventstore.EventCountKey.Int(len(events)),
+		eventstore.EventTypesKey.StringSlice(types),
+	}
+}
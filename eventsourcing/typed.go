@@ -0,0 +1,63 @@
+package eventsourcing
+
+import (
+	"context"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+)
+
+// TypedRepository is a Repository of aggregates of type T, usually a pointer to a struct such as *MyTodo.
+// Its Load, Apply, Create and Update return T rather than the Aggregate interface, so callers need no type assertion.
+// It embeds the Repository, whose other methods, like Save, it shares.
+type TypedRepository[T Aggregate] struct {
+	*Repository
+}
+
+// Load retrieves the specified aggregate from the underlying store, like Repository.Load
+func (r *TypedRepository[T]) Load(ctx context.Context, aggregateID string) (T, error) {
+	aggregate, err := r.Repository.Load(ctx, aggregateID)
+	return typed[T](aggregate, err)
+}
+
+// Apply creates new event(s) as a result of a command, like Repository.Apply
+func (r *TypedRepository[T]) Apply(ctx context.Context, command Command) (T, error) {
+	aggregate, err := r.Repository.Apply(ctx, command)
+	return typed[T](aggregate, err)
+}
+
+// Create creates new event(s) as a result of a command that starts an aggregate, like Repository.Create
+func (r *TypedRepository[T]) Create(ctx context.Context, command Command) (T, error) {
+	aggregate, err := r.Repository.Create(ctx, command)
+	return typed[T](aggregate, err)
+}
+
+// Update creates new event(s) as a result of a command to an existing aggregate, like Repository.Update
+func (r *TypedRepository[T]) Update(ctx context.Context, command Command) (T, error) {
+	aggregate, err := r.Repository.Update(ctx, command)
+	return typed[T](aggregate, err)
+}
+
+func typed[T Aggregate](aggregate Aggregate, err error) (T, error) {
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return aggregate.(T), nil
+}
+
+// NewTypedRepository creates a TypedRepository whose aggregates start as what factory returns.
+// The factory must return a new, empty aggregate on every call.
+func NewTypedRepository[T Aggregate](
+	factory func() T,
+	store eventstore.EventStore,
+	serializer Serializer,
+	observers []Observer,
+	opts ...Option,
+) *TypedRepository[T] {
+	untyped := func() Aggregate {
+		return factory()
+	}
+	return &TypedRepository[T]{
+		Repository: newRepository(untyped, typeName(factory()), store, serializer, observers, opts),
+	}
+}
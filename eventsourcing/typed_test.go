@@ -0,0 +1,84 @@
+package eventsourcing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedRepository(t *testing.T) {
+	ctx := context.Background()
+	serializer := NewJSONSerializer(TodoCreated{}, TodoDone{}, TodoUndone{})
+	created := 0
+	factory := func() *MyTodo {
+		created++
+		return &MyTodo{}
+	}
+	repo := NewTypedRepository(factory, eventstore.GetLocalStore(), serializer, nil)
+
+	t.Run("Apply and Load return the aggregate type", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		todo, err := repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", todo.Desc)
+
+		todo, err = repo.Apply(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		assert.True(ct, todo.Done)
+
+		todo, err = repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, id, todo.ID)
+		assert.True(ct, todo.Done)
+		assert.Equal(ct, 2, todo.Version)
+	})
+
+	t.Run("Save is the Repository's", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		err := repo.Save(ctx, TodoCreated{Model: Model{ID: id, Version: 1}, Desc: "Do that"})
+		assert.NoError(ct, err)
+
+		todo, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do that", todo.Desc)
+	})
+
+	t.Run("every aggregate comes from the factory", func(ct *testing.T) {
+		created = 0
+		first, err := repo.Load(ctx, uuid.NewV4().String())
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+		assert.Nil(ct, first)
+		assert.Equal(ct, 1, created)
+
+		var id = uuid.NewV4().String()
+		_, _ = repo.Apply(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		a, _ := repo.Load(ctx, id)
+		b, _ := repo.Load(ctx, id)
+		assert.NotSame(ct, a, b)
+	})
+
+	t.Run("a rejected command (error)", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		todo, err := repo.Apply(ctx, &MarkUndone{CommandModel{id}})
+		assert.Error(ct, err)
+		assert.Nil(ct, todo)
+	})
+
+	t.Run("Create and Update return the aggregate type", func(ct *testing.T) {
+		var id = uuid.NewV4().String()
+		todo, err := repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", todo.Desc)
+
+		todo, err = repo.Update(ctx, &MarkDone{CommandModel{id}})
+		assert.NoError(ct, err)
+		assert.True(ct, todo.Done)
+
+		todo, err = repo.Create(ctx, &CreateTodo{CommandModel: CommandModel{ID: id}, Desc: "Do that"})
+		assert.ErrorIs(ct, err, ErrAggregateExists)
+		assert.Nil(ct, todo)
+	})
+}
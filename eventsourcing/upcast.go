@@ -0,0 +1,63 @@
+package eventsourcing
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventPayload is the JSON of a stored event along with its type name and schema version
+type EventPayload struct {
+	Type          string
+	SchemaVersion int
+	Data          json.RawMessage
+}
+
+// Upcaster converts the payload of an event stored at an old schema version into a newer shape.
+// It may change the type as well as the data: to rename an event type, or to split one in several types,
+// the upcaster of the old type returns the payload of whichever new type fits the data, at that type's
+// schema version.
+type Upcaster func(payload EventPayload) (EventPayload, error)
+
+type upcasterKey struct {
+	eventType     string
+	schemaVersion int
+}
+
+// Upcast registers the upcaster of events of eventType stored at schemaVersion; may be called more than once.
+// Upcasters are chained: the payload an upcaster returns goes through the upcaster registered for its type
+// and schema version, if any, until it reaches the schema version its type is bound at.
+func (j *JSONSerializer) Upcast(eventType string, schemaVersion int, upcaster Upcaster) {
+	j.upcasters[upcasterKey{eventType, schemaVersion}] = upcaster
+}
+
+// upcast runs a stored payload through the chain of upcasters
+func (j *JSONSerializer) upcast(payload EventPayload) (EventPayload, error) {
+	if payload.SchemaVersion == 0 {
+		payload.SchemaVersion = 1
+	}
+
+	// A chain longer than the number of upcasters goes around in circles
+	for steps := 0; ; steps++ {
+		upcaster, ok := j.upcasters[upcasterKey{payload.Type, payload.SchemaVersion}]
+		if !ok {
+			break
+		}
+		if steps == len(j.upcasters) {
+			return EventPayload{}, fmt.Errorf("upcasters of event type %v loop", payload.Type)
+		}
+
+		from := payload
+		var err error
+		if payload, err = upcaster(from); err != nil {
+			return EventPayload{}, fmt.Errorf("unable to upcast event type %v from schema version %d: %w", from.Type, from.SchemaVersion, err)
+		}
+		if payload.SchemaVersion == 0 {
+			payload.SchemaVersion = 1
+		}
+	}
+
+	if bound, ok := j.schemaVersions[payload.Type]; ok && payload.SchemaVersion != bound {
+		return EventPayload{}, fmt.Errorf("no upcaster for event type %v from schema version %d to %d", payload.Type, payload.SchemaVersion, bound)
+	}
+	return payload, nil
+}
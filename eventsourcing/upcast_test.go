@@ -0,0 +1,146 @@
+package eventsourcing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cannahum/eventsourcing-lite/eventstore"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// renameField returns an upcaster that renames a field of the event data and moves the event to schemaVersion
+func renameField(from, to string, schemaVersion int) Upcaster {
+	return func(payload EventPayload) (EventPayload, error) {
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(payload.Data, &fields); err != nil {
+			return EventPayload{}, err
+		}
+		fields[to] = fields[from]
+		delete(fields, from)
+
+		data, err := json.Marshal(fields)
+		return EventPayload{Type: payload.Type, SchemaVersion: schemaVersion, Data: data}, err
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	id := uuid.NewV4().String()
+	record := func(data string) eventstore.Record {
+		return eventstore.Record{Version: 1, Data: []byte(data)}
+	}
+
+	t.Run("events stored without a schema version are at version 1", func(ct *testing.T) {
+		serializer := NewJSONSerializer(TodoCreated{})
+		event, err := serializer.UnmarshalEvent(record(`{"t":"TodoCreated","d":{"ID":"` + id + `","Desc":"Do this"}}`))
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", event.(*TodoCreated).Desc)
+	})
+
+	t.Run("events are stored with the schema version they are bound at", func(ct *testing.T) {
+		serializer := NewJSONSerializer()
+		serializer.BindVersion(2, TodoCreated{})
+		serializer.Upcast("TodoCreated", 1, renameField("Description", "Desc", 2))
+
+		stored, err := serializer.MarshalEvent(TodoCreated{Model: Model{ID: id, Version: 1}, Desc: "Do this"})
+		assert.NoError(ct, err)
+		wrapper := jsonEvent{}
+		assert.NoError(ct, json.Unmarshal(stored.Data, &wrapper))
+		assert.Equal(ct, 2, wrapper.SchemaVersion)
+
+		// The upcaster only applies to events stored at version 1
+		event, err := serializer.UnmarshalEvent(stored)
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", event.(*TodoCreated).Desc)
+	})
+
+	t.Run("upcasters are chained across renamed types", func(ct *testing.T) {
+		serializer := NewJSONSerializer()
+		serializer.BindVersion(3, TodoCreated{})
+		serializer.Upcast("TodoAdded", 1, func(payload EventPayload) (EventPayload, error) {
+			return EventPayload{Type: "TodoCreated", SchemaVersion: 1, Data: payload.Data}, nil
+		})
+		serializer.Upcast("TodoCreated", 1, renameField("Text", "Description", 2))
+		serializer.Upcast("TodoCreated", 2, renameField("Description", "Desc", 3))
+
+		event, err := serializer.UnmarshalEvent(record(`{"t":"TodoAdded","d":{"ID":"` + id + `","Text":"Do this"}}`))
+		assert.NoError(ct, err)
+		assert.Equal(ct, "Do this", event.(*TodoCreated).Desc)
+		assert.Equal(ct, id, event.AggregateID())
+	})
+
+	t.Run("an upcaster can split an event type", func(ct *testing.T) {
+		serializer := NewJSONSerializer(TodoDone{}, TodoUndone{})
+		serializer.Upcast("TodoToggled", 1, func(payload EventPayload) (EventPayload, error) {
+			toggled := struct{ Done bool }{}
+			if err := json.Unmarshal(payload.Data, &toggled); err != nil {
+				return EventPayload{}, err
+			}
+			if toggled.Done {
+				return EventPayload{Type: "TodoDone", Data: payload.Data}, nil
+			}
+			return EventPayload{Type: "TodoUndone", Data: payload.Data}, nil
+		})
+
+		event, err := serializer.UnmarshalEvent(record(`{"t":"TodoToggled","d":{"ID":"` + id + `","Done":true}}`))
+		assert.NoError(ct, err)
+		assert.IsType(ct, &TodoDone{}, event)
+		event, err = serializer.UnmarshalEvent(record(`{"t":"TodoToggled","d":{"ID":"` + id + `","Done":false}}`))
+		assert.NoError(ct, err)
+		assert.IsType(ct, &TodoUndone{}, event)
+	})
+
+	t.Run("an event without a way to its bound schema version (error)", func(ct *testing.T) {
+		serializer := NewJSONSerializer()
+		serializer.BindVersion(2, TodoCreated{})
+		_, err := serializer.UnmarshalEvent(record(`{"t":"TodoCreated","d":{"ID":"` + id + `","Description":"Do this"}}`))
+		assert.Error(ct, err)
+	})
+
+	t.Run("a failing upcaster (error)", func(ct *testing.T) {
+		serializer := NewJSONSerializer()
+		serializer.BindVersion(2, TodoCreated{})
+		upcastErr := errors.New("unreadable")
+		serializer.Upcast("TodoCreated", 1, func(EventPayload) (EventPayload, error) {
+			return EventPayload{}, upcastErr
+		})
+		_, err := serializer.UnmarshalEvent(record(`{"t":"TodoCreated","d":{}}`))
+		assert.ErrorIs(ct, err, upcastErr)
+	})
+
+	t.Run("upcasters going in circles (error)", func(ct *testing.T) {
+		serializer := NewJSONSerializer(TodoCreated{})
+		serializer.Upcast("TodoCreated", 1, func(payload EventPayload) (EventPayload, error) {
+			return EventPayload{Type: "TodoAdded", Data: payload.Data}, nil
+		})
+		serializer.Upcast("TodoAdded", 1, func(payload EventPayload) (EventPayload, error) {
+			return EventPayload{Type: "TodoCreated", Data: payload.Data}, nil
+		})
+		_, err := serializer.UnmarshalEvent(record(`{"t":"TodoCreated","d":{}}`))
+		assert.Error(ct, err)
+	})
+
+	t.Run("the Repository loads upcast events", func(ct *testing.T) {
+		ctx := context.Background()
+		store := eventstore.GetLocalStore()
+		_ = store.Save(ctx, id, 0,
+			record(`{"t":"TodoCreated","d":{"ID":"`+id+`","Version":1,"Description":"Do this"}}`),
+			eventstore.Record{Version: 2, Data: []byte(`{"t":"TodoDone","d":{"ID":"` + id + `","Version":2}}`)},
+		)
+
+		serializer := NewJSONSerializer(TodoDone{}, TodoUndone{})
+		serializer.BindVersion(2, TodoCreated{})
+		serializer.Upcast("TodoCreated", 1, renameField("Description", "Desc", 2))
+		repo := NewRepository(reflect.TypeOf(MyTodo{}), store, serializer, nil)
+
+		agg, err := repo.Load(ctx, id)
+		assert.NoError(ct, err)
+		todo := agg.(*MyTodo)
+		assert.Equal(ct, "Do this", todo.Desc)
+		assert.True(ct, todo.Done)
+		assert.Equal(ct, 2, todo.Version)
+	})
+}
@@ -0,0 +1,49 @@
+package eventstore
+
+import (
+	"context"
+	"sync"
+)
+
+// CheckpointStore keeps how far each subscriber got in each shard of a change feed,
+// so a subscriber that restarts picks up where it left off
+type CheckpointStore interface {
+	// SaveCheckpoint stores the sequence number of the last record a subscriber handled in a shard
+	SaveCheckpoint(ctx context.Context, subscriberID, shardID, sequenceNumber string) error
+
+	// LoadCheckpoint returns the sequence number saved for a subscriber and a shard, or "" when there is none
+	LoadCheckpoint(ctx context.Context, subscriberID, shardID string) (string, error)
+}
+
+type memoryCheckpointStore struct {
+	mux         *sync.Mutex
+	checkpoints map[string]string
+}
+
+func (m *memoryCheckpointStore) SaveCheckpoint(_ context.Context, subscriberID, shardID, sequenceNumber string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.checkpoints[checkpointID(subscriberID, shardID)] = sequenceNumber
+	return nil
+}
+
+func (m *memoryCheckpointStore) LoadCheckpoint(_ context.Context, subscriberID, shardID string) (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.checkpoints[checkpointID(subscriberID, shardID)], nil
+}
+
+// checkpointID identifies the checkpoint of a subscriber in a shard
+func checkpointID(subscriberID, shardID string) string {
+	return subscriberID + "/" + shardID
+}
+
+// GetLocalCheckpointStore returns a CheckpointStore in memory - good for tests!
+func GetLocalCheckpointStore() CheckpointStore {
+	return &memoryCheckpointStore{
+		mux:         &sync.Mutex{},
+		checkpoints: map[string]string{},
+	}
+}
@@ -0,0 +1,32 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalCheckpointStore()
+
+	t.Run("test LoadCheckpoint without a checkpoint", func(ct *testing.T) {
+		sequenceNumber, err := s.LoadCheckpoint(ctx, "subscriber", "shard-1")
+		assert.Nil(ct, err)
+		assert.Equal(ct, "", sequenceNumber)
+	})
+
+	t.Run("test checkpoints are kept by subscriber and shard", func(ct *testing.T) {
+		assert.Nil(ct, s.SaveCheckpoint(ctx, "subscriber", "shard-1", "100"))
+		assert.Nil(ct, s.SaveCheckpoint(ctx, "subscriber", "shard-1", "200"))
+		assert.Nil(ct, s.SaveCheckpoint(ctx, "other", "shard-1", "300"))
+
+		sequenceNumber, _ := s.LoadCheckpoint(ctx, "subscriber", "shard-1")
+		assert.Equal(ct, "200", sequenceNumber)
+		sequenceNumber, _ = s.LoadCheckpoint(ctx, "other", "shard-1")
+		assert.Equal(ct, "300", sequenceNumber)
+		sequenceNumber, _ = s.LoadCheckpoint(ctx, "subscriber", "shard-2")
+		assert.Equal(ct, "", sequenceNumber)
+	})
+}
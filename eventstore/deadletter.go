@@ -0,0 +1,122 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an event an observer failed to observe, kept so it can be inspected and observed again
+type DeadLetter struct {
+	// ID contains the unique id of the dead letter
+	ID string `dynamodbav:"-"`
+
+	// Observer contains the name of the observer that failed
+	Observer string `dynamodbav:"observer"`
+
+	// AggregateID contains the id of the aggregate the event belongs to
+	AggregateID string `dynamodbav:"aggregate_id"`
+
+	// Record contains the event
+	Record Record `dynamodbav:"record"`
+
+	// Error contains the error of the last attempt to observe the event
+	Error string `dynamodbav:"error"`
+
+	// Attempts contains how many times the observer tried to observe the event
+	Attempts int `dynamodbav:"attempts"`
+
+	// FailedAt indicates when the last attempt failed
+	FailedAt time.Time `dynamodbav:"failed_at"`
+}
+
+// DeadLetterStore keeps the events observers failed to observe, until they are observed again or discarded
+type DeadLetterStore interface {
+	// SaveDeadLetter stores a dead letter, replacing the one with the same id
+	SaveDeadLetter(ctx context.Context, letter DeadLetter) error
+
+	// ListDeadLetters returns every dead letter, the one that failed first first
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+
+	// LoadDeadLetter returns a dead letter, or ErrDeadLetterNotFound when there is none with the id
+	LoadDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+
+	// DeleteDeadLetter discards a dead letter; discarding one that does not exist is not an error
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+// deadLetterNotFound returns ErrDeadLetterNotFound for a dead letter
+func deadLetterNotFound(id string) error {
+	return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+}
+
+// sortDeadLetters orders dead letters by when they failed, and by id when they failed at once
+func sortDeadLetters(letters []DeadLetter) {
+	sort.Slice(letters, func(i, j int) bool {
+		if !letters[i].FailedAt.Equal(letters[j].FailedAt) {
+			return letters[i].FailedAt.Before(letters[j].FailedAt)
+		}
+		return letters[i].ID < letters[j].ID
+	})
+}
+
+type memoryDeadLetterStore struct {
+	mux         *sync.Mutex
+	deadLetters map[string]DeadLetter
+}
+
+func (m *memoryDeadLetterStore) SaveDeadLetter(_ context.Context, letter DeadLetter) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.deadLetters[letter.ID] = copyDeadLetter(letter)
+	return nil
+}
+
+func (m *memoryDeadLetterStore) ListDeadLetters(_ context.Context) ([]DeadLetter, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	letters := make([]DeadLetter, 0, len(m.deadLetters))
+	for _, letter := range m.deadLetters {
+		letters = append(letters, copyDeadLetter(letter))
+	}
+	sortDeadLetters(letters)
+	return letters, nil
+}
+
+func (m *memoryDeadLetterStore) LoadDeadLetter(_ context.Context, id string) (*DeadLetter, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	letter, ok := m.deadLetters[id]
+	if !ok {
+		return nil, deadLetterNotFound(id)
+	}
+	letter = copyDeadLetter(letter)
+	return &letter, nil
+}
+
+func (m *memoryDeadLetterStore) DeleteDeadLetter(_ context.Context, id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	delete(m.deadLetters, id)
+	return nil
+}
+
+// copyDeadLetter returns a copy of a dead letter that shares no memory with it
+func copyDeadLetter(letter DeadLetter) DeadLetter {
+	letter.Record = cloneRecord(letter.Record)
+	return letter
+}
+
+// GetLocalDeadLetterStore returns a DeadLetterStore in memory - good for tests!
+func GetLocalDeadLetterStore() DeadLetterStore {
+	return &memoryDeadLetterStore{
+		mux:         &sync.Mutex{},
+		deadLetters: map[string]DeadLetter{},
+	}
+}
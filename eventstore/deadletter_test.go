@@ -0,0 +1,81 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDeadLetterStore runs the tests every DeadLetterStore must pass against an empty store
+func testDeadLetterStore(t *testing.T, s DeadLetterStore) {
+	ctx := context.Background()
+	at := time.Now().UTC()
+	first := DeadLetter{
+		ID:          uuid.NewV4().String(),
+		Observer:    "Notifier",
+		AggregateID: uuid.NewV4().String(),
+		Record: Record{
+			Version:    2,
+			Data:       []byte(`{"Desc":"Do this"}`),
+			EventType:  "TodoCreated",
+			OccurredAt: at,
+			Headers:    map[string]string{"tenant": "acme"},
+		},
+		Error:    "webhook unavailable",
+		Attempts: 3,
+		FailedAt: at,
+	}
+	second := first
+	second.ID = uuid.NewV4().String()
+	second.FailedAt = at.Add(time.Second)
+
+	t.Run("test LoadDeadLetter of an unknown dead letter (error)", func(ct *testing.T) {
+		letter, err := s.LoadDeadLetter(ctx, uuid.NewV4().String())
+		assert.Nil(ct, letter)
+		assert.ErrorIs(ct, err, ErrDeadLetterNotFound)
+	})
+
+	t.Run("test SaveDeadLetter -> LoadDeadLetter", func(ct *testing.T) {
+		assert.Nil(ct, s.SaveDeadLetter(ctx, first))
+
+		letter, err := s.LoadDeadLetter(ctx, first.ID)
+		assert.Nil(ct, err)
+		assert.Equal(ct, first.Observer, letter.Observer)
+		assert.Equal(ct, first.AggregateID, letter.AggregateID)
+		assert.Equal(ct, first.Record.Data, letter.Record.Data)
+		assert.Equal(ct, first.Record.Version, letter.Record.Version)
+		assert.Equal(ct, first.Record.Headers, letter.Record.Headers)
+		assert.True(ct, at.Equal(letter.FailedAt))
+		assert.Equal(ct, 3, letter.Attempts)
+	})
+
+	t.Run("test ListDeadLetters returns the first failure first", func(ct *testing.T) {
+		assert.Nil(ct, s.SaveDeadLetter(ctx, second))
+		first.Attempts = 4
+		assert.Nil(ct, s.SaveDeadLetter(ctx, first))
+
+		letters, err := s.ListDeadLetters(ctx)
+		assert.Nil(ct, err)
+		assert.Len(ct, letters, 2)
+		assert.Equal(ct, first.ID, letters[0].ID)
+		assert.Equal(ct, 4, letters[0].Attempts)
+		assert.Equal(ct, second.ID, letters[1].ID)
+	})
+
+	t.Run("test DeleteDeadLetter discards a dead letter", func(ct *testing.T) {
+		assert.Nil(ct, s.DeleteDeadLetter(ctx, first.ID))
+		assert.Nil(ct, s.DeleteDeadLetter(ctx, first.ID))
+
+		_, err := s.LoadDeadLetter(ctx, first.ID)
+		assert.ErrorIs(ct, err, ErrDeadLetterNotFound)
+		letters, _ := s.ListDeadLetters(ctx)
+		assert.Len(ct, letters, 1)
+	})
+}
+
+func TestLocalDeadLetterStore(t *testing.T) {
+	testDeadLetterStore(t, GetLocalDeadLetterStore())
+}
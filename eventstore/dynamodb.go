@@ -4,44 +4,128 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"reflect"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/cannahum/eventsourcing-lite/metrics"
+)
+
+// Attributes that hold a record's metadata
+const (
+	eventTypeAttribute     = "event_type"
+	eventIDAttribute       = "event_id"
+	occurredAtAttribute    = "occurred_at"
+	correlationIDAttribute = "correlation_id"
+	causationIDAttribute   = "causation_id"
+	headersAttribute       = "headers"
 )
 
 // ConditionalCheckFailed is const for DB error
 const ConditionalCheckFailed = "ConditionalCheckFailed"
 
+// TransactionConflict is const for DB error raised when another transaction is writing the same items
+const TransactionConflict = "TransactionConflict"
+
 // MaxBatchEventCount specifies how many new events we are willing to process in one command
+//
+// Deprecated: Save splits batches of any size into transactions of at most MaxTransactionItems.
 const MaxBatchEventCount = 25
 
+// MaxTransactionItems specifies how many items DynamoDB accepts in one TransactWriteItems call
+const MaxTransactionItems = 100
+
+// maxTransactionBytes is the aggregate size DynamoDB accepts in one TransactWriteItems call
+const maxTransactionBytes = 4 * 1024 * 1024
+
+// itemOverheadBytes is a generous estimate of an item's size besides its event data (keys and attribute names)
+const itemOverheadBytes = 1024
+
 // DynamoDBStore is an event store implementation using DynamoDB
 // This is an object that represents metadata on this table
 type DynamoDBStore struct {
 	tableName string
 	hashKey   string
 	rangeKey  string
+	pageSize  int32
+	globalLog bool
 	api       *dynamodb.Client
+
+	batchTimeout time.Duration
+
+	outboxTableName string
+	metrics         metrics.Recorder
+}
+
+// DynamoDBOption configures optional behavior of a DynamoDBStore
+type DynamoDBOption func(*DynamoDBStore)
+
+// WithPageSize hints how many records Load should request per Query page.
+// DynamoDB still ends a page early once it has read 1 MB; 0 leaves the page size up to DynamoDB.
+func WithPageSize(size int32) DynamoDBOption {
+	return func(s *DynamoDBStore) {
+		s.pageSize = size
+	}
+}
+
+// WithGlobalLog makes the store keep a global log of every record in commit order, readable through ReadAll.
+// The log lives in the same table, under the reserved aggregate id "$all", which the store refuses for
+// aggregates with ErrReservedAggregateID.
+// Every write moves a single sequence counter, so writes to the table commit one at a time, whatever their
+// aggregate: throughput tops out at a few hundred writes per second, less the more writers contend, and a write
+// that loses the race for the counter 25 times in a row fails. Leave the log off for write-heavy tables.
+// ReadAll recovers batches left uncommitted for longer than the batch timeout, see WithBatchTimeout.
+func WithGlobalLog() DynamoDBOption {
+	return func(s *DynamoDBStore) {
+		s.globalLog = true
+	}
+}
+
+// WithBatchTimeout sets how long a batch may stay uncommitted before ReadAll takes its writer for crashed and
+// recovers the batch's stream, like Recover, instead of waiting for it; the default is DefaultBatchTimeout.
+// 0 makes ReadAll wait until Recover is run. The timeout must be well above how long the slowest batch takes
+// to write, or ReadAll rolls back batches that would have committed.
+func WithBatchTimeout(timeout time.Duration) DynamoDBOption {
+	return func(s *DynamoDBStore) {
+		s.batchTimeout = timeout
+	}
+}
+
+// WithMetrics makes the store record with recorder every write that DynamoDB rejects because of a failed
+// condition, whether a concurrent writer moved the stream or the global log's sequence counter.
+// Wrap the store with GetMetricsStore to count the events it saves as well.
+func WithMetrics(recorder metrics.Recorder) DynamoDBOption {
+	return func(s *DynamoDBStore) {
+		s.metrics = recorder
+	}
 }
 
 // GetDynamoDBStore returns a new DB store instance
-func GetDynamoDBStore(tableName, partitionKey, rangeKey string, db *dynamodb.Client) *DynamoDBStore {
+func GetDynamoDBStore(tableName, partitionKey, rangeKey string, db *dynamodb.Client, opts ...DynamoDBOption) *DynamoDBStore {
 	store := DynamoDBStore{
 		tableName: tableName,
 		hashKey:   partitionKey,
 		rangeKey:  rangeKey,
+		metrics:   metrics.Nop{},
+
+		batchTimeout: DefaultBatchTimeout,
 	}
 	store.api = db
+	for _, opt := range opts {
+		opt(&store)
+	}
 	return &store
 }
 
 // Load implements the EventStore interface and reads all events for a specific aggregateID
 func (s *DynamoDBStore) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	if err := checkAggregateID(aggregateID); err != nil {
+		return nil, err
+	}
 	input := &dynamodb.QueryInput{
 		TableName:      aws.String(s.tableName),
 		Select:         types.SelectAllAttributes,
@@ -68,100 +152,406 @@ func (s *DynamoDBStore) Load(ctx context.Context, aggregateID string, fromVersio
 		input.KeyConditionExpression = aws.String("#key = :key")
 	}
 
-	history := make(History, 0, toVersion)
-	out, err := s.api.Query(ctx, input)
-	if err != nil {
-		return nil, err
+	if s.pageSize > 0 {
+		input.Limit = aws.Int32(s.pageSize)
 	}
-	var records []Record
-	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
+
+	items, err := s.query(ctx, input)
 	if err != nil {
 		return nil, err
 	}
-	return append(history, records...), nil
+	history, err := s.visible(ctx, aggregateID, items, fromVersion, toVersion)
+	if err != nil || len(history) > 0 {
+		return history, err
+	}
+
+	// Versions start at 1, so nothing from the start means no stream; past it, the stream may still exist
+	if fromVersion > 1 {
+		var version int
+		if version, err = s.currentVersion(ctx, aggregateID); err != nil || version > 0 {
+			return history, err
+		}
+	}
+	return nil, notFound(aggregateID)
+}
+
+// query runs a Query to completion; a single Query returns at most 1 MB, so it keeps following LastEvaluatedKey
+func (s *DynamoDBStore) query(ctx context.Context, input *dynamodb.QueryInput) ([]dynamoRecord, error) {
+	var items []dynamoRecord
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		input.ReturnConsumedCapacity = returnCapacity(ctx)
+		out, err := s.api.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		recordCapacity(ctx, out.ConsumedCapacity)
+		records, err := s.decode(out.Items)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, records...)
+
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
 }
 
-// Save implements the EventStore interface and stores an event in DynamoDB
-func (s *DynamoDBStore) Save(ctx context.Context, aggregateID string, records ...Record) error {
+// Save implements the EventStore interface and stores an event in DynamoDB.
+// Records that don't fit in one transaction are written as a pending batch over several transactions;
+// Load only returns them once the transaction holding the batch's last record has committed.
+func (s *DynamoDBStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	if err := checkAggregateID(aggregateID); err != nil {
+		return err
+	}
 	if len(records) == 0 {
 		return nil
 	}
 
-	if len(records) > MaxBatchEventCount {
-		return errors.New("not implemented: can't save 25 events at a time")
+	if err := checkVersions(expectedVersion, records); err != nil {
+		return err
+	}
+
+	chunks := chunkRecords(records, s.itemsPerRecord())
+	if len(chunks) > 1 {
+		return s.saveBatch(ctx, aggregateID, expectedVersion, chunks)
+	}
+
+	err := s.write(ctx, aggregateID, expectedVersion, nil, records)
+	if isConditionFailure(err) {
+		return s.conflict(ctx, aggregateID, expectedVersion)
+	}
+	return err
+}
+
+// itemsPerRecord is how many transaction items it takes to write one record
+func (s *DynamoDBStore) itemsPerRecord() int {
+	items := 1
+	if s.globalLog {
+		items++
+	}
+	if s.outboxTableName != "" {
+		items++
+	}
+	return items
+}
+
+// SaveAll implements the MultiStreamSaver interface and stores the records of every stream in one transaction.
+// Unlike Save, it cannot spread records over several transactions, so together they must fit in one.
+func (s *DynamoDBStore) SaveAll(ctx context.Context, appends ...Append) error {
+	for _, a := range appends {
+		if err := checkAggregateID(a.AggregateID); err != nil {
+			return err
+		}
+	}
+	if err := checkAppends(appends); err != nil {
+		return err
+	}
+
+	var streams []Append
+	items, size := 0, 0
+	if s.globalLog {
+		items++ // the sequence counter
+	}
+	for _, a := range appends {
+		if len(a.Records) == 0 {
+			continue
+		}
+		streams = append(streams, a)
+		if a.ExpectedVersion > 0 {
+			items++
+		}
+		items += len(a.Records) * s.itemsPerRecord()
+		for _, record := range a.Records {
+			size += (recordBytes(record) + itemOverheadBytes) * s.itemsPerRecord()
+		}
 	}
 
-	sort.Slice(records, func(i, j int) bool {
-		return records[i].Version < records[j].Version
-	})
+	switch {
+	case len(streams) == 0:
+		return nil
+	case len(streams) == 1:
+		return s.Save(ctx, streams[0].AggregateID, streams[0].ExpectedVersion, streams[0].Records...)
+	case items > MaxTransactionItems || size > maxTransactionBytes:
+		return fmt.Errorf("the records of %d streams do not fit in one transaction", len(streams))
+	}
 
-	for i := len(records) - 2; i >= 0; i-- {
-		if records[i].Version == records[i+1].Version {
-			return errors.New("duplicate version detected")
+	err := s.writeStreams(ctx, nil, streams...)
+	if !isConditionFailure(err) {
+		return err
+	}
+	for _, stream := range streams {
+		actualVersion, versionErr := s.currentVersion(ctx, stream.AggregateID)
+		if versionErr != nil {
+			return versionErr
+		}
+		if actualVersion != stream.ExpectedVersion {
+			return &ErrConcurrencyConflict{
+				AggregateID:     stream.AggregateID,
+				ExpectedVersion: stream.ExpectedVersion,
+				ActualVersion:   actualVersion,
+			}
 		}
 	}
+	return err
+}
+
+// write stores the records in a single transaction; batch is nil unless the records are a chunk of a pending batch
+func (s *DynamoDBStore) write(ctx context.Context, aggregateID string, expectedVersion int, batch *pendingBatch, records []Record) error {
+	return s.writeStreams(ctx, batch, Append{AggregateID: aggregateID, ExpectedVersion: expectedVersion, Records: records})
+}
+
+// writeStreams stores the records of one or more streams in a single transaction
+func (s *DynamoDBStore) writeStreams(ctx context.Context, batch *pendingBatch, streams ...Append) error {
+	if s.globalLog {
+		return s.writeLogged(ctx, batch, streams)
+	}
 
 	input := &dynamodb.TransactWriteItemsInput{}
+	for _, stream := range streams {
+		input.TransactItems = append(input.TransactItems, s.writeItems(stream.AggregateID, stream.ExpectedVersion, batch, stream.Records)...)
+	}
+	input.TransactItems = append(input.TransactItems, s.outboxStreams(batch, streams)...)
+	return s.transact(ctx, input)
+}
 
-	for _, e := range records {
-		keyClause := map[string]types.AttributeValue{}
-		keyClause[s.hashKey] = &types.AttributeValueMemberS{Value: aggregateID}
-		keyClause[s.rangeKey] = &types.AttributeValueMemberN{Value: strconv.Itoa(e.Version)}
-
-		twi := types.TransactWriteItem{
-			Update: &types.Update{
-				TableName: aws.String(s.tableName),
-				Key:       keyClause,
+// transact runs a write transaction and records the capacity it consumed
+func (s *DynamoDBStore) transact(ctx context.Context, input *dynamodb.TransactWriteItemsInput) error {
+	input.ReturnConsumedCapacity = returnCapacity(ctx)
+	out, err := s.api.TransactWriteItems(ctx, input)
+	if err != nil {
+		if hasConditionFailure(err) {
+			s.metrics.ConditionalCheckFailed(s.tableName)
+		}
+		return err
+	}
+	for i := range out.ConsumedCapacity {
+		recordCapacity(ctx, &out.ConsumedCapacity[i])
+	}
+	return nil
+}
+
+// returnCapacity asks DynamoDB to report the capacity a call consumes when ctx collects it, see GetTracingStore
+func returnCapacity(ctx context.Context) types.ReturnConsumedCapacity {
+	if measuringCapacity(ctx) {
+		return types.ReturnConsumedCapacityTotal
+	}
+	return types.ReturnConsumedCapacityNone
+}
+
+// recordCapacity adds the capacity a call consumed to what ctx collects
+func recordCapacity(ctx context.Context, consumed *types.ConsumedCapacity) {
+	if consumed != nil {
+		addCapacity(ctx, aws.ToFloat64(consumed.CapacityUnits))
+	}
+}
+
+// writeItems builds the transaction items that guard the stream's version and add the records to it.
+// The records' updates are the last items.
+func (s *DynamoDBStore) writeItems(
+	aggregateID string,
+	expectedVersion int,
+	batch *pendingBatch,
+	records []Record,
+) []types.TransactWriteItem {
+	var items []types.TransactWriteItem
+
+	switch {
+	case batch != nil && records[0].Version != batch.first:
+		// Later chunks of a batch may only land while the batch's first record is still there;
+		// once a rollback or Recover removed it, the batch can never commit.
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String(s.tableName),
+				Key:                 s.key(aggregateID, batch.first),
+				ConditionExpression: aws.String("#batch = :batch"),
+				ExpressionAttributeNames: map[string]string{
+					"#batch": batchIDAttribute,
+				},
 				ExpressionAttributeValues: map[string]types.AttributeValue{
-					":r": &types.AttributeValueMemberB{Value: e.Data},
+					":batch": &types.AttributeValueMemberS{Value: batch.id},
+				},
+			},
+		})
+	case expectedVersion > 0:
+		// The record the caller built upon must exist; the first new record below must not.
+		// Together they pin the stream at exactly expectedVersion.
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String(s.tableName),
+				Key:                 s.key(aggregateID, expectedVersion),
+				ConditionExpression: aws.String("attribute_exists(#version)"),
+				ExpressionAttributeNames: map[string]string{
+					"#version": s.rangeKey,
 				},
-				ConditionExpression: aws.String(
-					fmt.Sprintf("attribute_not_exists(%s)", s.rangeKey),
-				),
-				UpdateExpression: aws.String("set event_data = :r"),
 			},
+		})
+	}
+
+	for _, e := range records {
+		update := &types.Update{
+			TableName: aws.String(s.tableName),
+			Key:       s.key(aggregateID, e.Version),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":r": &types.AttributeValueMemberB{Value: e.Data},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(#version)"),
+			ExpressionAttributeNames: map[string]string{
+				"#version": s.rangeKey,
+			},
+			UpdateExpression: aws.String("set event_data = :r"),
+		}
+		setMetadata(update, e)
+		if batch != nil {
+			batch.mark(update)
 		}
-		input.TransactItems = append(input.TransactItems, twi)
+		items = append(items, types.TransactWriteItem{Update: update})
 	}
+	return items
+}
 
-	_, err := s.api.TransactWriteItems(ctx, input)
-	if err != nil {
-		if txnCanceled, ok := err.(*types.TransactionCanceledException); ok {
-			// awsError.Code().
-			// if len() {}
-			// if v.Code() == ConditionalCheckFailed {
-			// 	return s.ensureIdempotent(ctx, aggregateID, records...)
-			// }
-			for _, reason := range txnCanceled.CancellationReasons {
-				if *reason.Code == ConditionalCheckFailed {
-					return s.ensureIdempotent(ctx, aggregateID, records...)
-				}
-			}
+// metadataAttributes returns the record's metadata as item attributes, leaving out what is empty
+func metadataAttributes(record Record) map[string]types.AttributeValue {
+	attributes := map[string]types.AttributeValue{}
+	for name, value := range map[string]string{
+		eventTypeAttribute:     record.EventType,
+		eventIDAttribute:       record.EventID,
+		correlationIDAttribute: record.CorrelationID,
+		causationIDAttribute:   record.CausationID,
+	} {
+		if value != "" {
+			attributes[name] = &types.AttributeValueMemberS{Value: value}
 		}
-		return err
 	}
-	return nil
+	if !record.OccurredAt.IsZero() {
+		attributes[occurredAtAttribute] = &types.AttributeValueMemberS{Value: record.OccurredAt.UTC().Format(time.RFC3339Nano)}
+	}
+	if len(record.Headers) > 0 {
+		headers := make(map[string]types.AttributeValue, len(record.Headers))
+		for k, v := range record.Headers {
+			headers[k] = &types.AttributeValueMemberS{Value: v}
+		}
+		attributes[headersAttribute] = &types.AttributeValueMemberM{Value: headers}
+	}
+	return attributes
 }
 
-func (s *DynamoDBStore) ensureIdempotent(ctx context.Context, aggregateID string, records ...Record) error {
-	if len(records) == 0 {
-		return nil
+// setMetadata adds the record's metadata attributes to a record update
+func setMetadata(update *types.Update, record Record) {
+	attributes := metadataAttributes(record)
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expression := aws.ToString(update.UpdateExpression)
+	for i, name := range names {
+		placeholder := "m" + strconv.Itoa(i)
+		expression += ", #" + placeholder + " = :" + placeholder
+		update.ExpressionAttributeNames["#"+placeholder] = name
+		update.ExpressionAttributeValues[":"+placeholder] = attributes[name]
+	}
+	update.UpdateExpression = aws.String(expression)
+}
+
+// isConditionFailure reports whether a transaction was cancelled because another writer got there first
+func isConditionFailure(err error) bool {
+	var txnCanceled *types.TransactionCanceledException
+	if !errors.As(err, &txnCanceled) {
+		return false
 	}
+	for _, reason := range txnCanceled.CancellationReasons {
+		if code := aws.ToString(reason.Code); code == ConditionalCheckFailed || code == TransactionConflict {
+			return true
+		}
+	}
+	return false
+}
 
-	version := records[len(records)-1].Version
-	history, err := s.Load(ctx, aggregateID, 0, version)
+// hasConditionFailure reports whether a transaction was cancelled because one of its conditions failed
+func hasConditionFailure(err error) bool {
+	var txnCanceled *types.TransactionCanceledException
+	if !errors.As(err, &txnCanceled) {
+		return false
+	}
+	for _, reason := range txnCanceled.CancellationReasons {
+		if aws.ToString(reason.Code) == ConditionalCheckFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// conflict builds the error returned when a write was rejected because the stream moved
+func (s *DynamoDBStore) conflict(ctx context.Context, aggregateID string, expectedVersion int) error {
+	actualVersion, err := s.currentVersion(ctx, aggregateID)
 	if err != nil {
 		return err
 	}
-	if len(history) < len(records) {
-		return errors.New(ConditionalCheckFailed)
+	return &ErrConcurrencyConflict{
+		AggregateID:     aggregateID,
+		ExpectedVersion: expectedVersion,
+		ActualVersion:   actualVersion,
 	}
+}
+
+// currentVersion reads the version of the last committed record of a stream; 0 when the stream is empty.
+// Like Load, it looks past the records of a batch that is still pending, as its last record is not written yet.
+func (s *DynamoDBStore) currentVersion(ctx context.Context, aggregateID string) (int, error) {
+	input := s.batchQuery(aggregateID)
+	input.ReturnConsumedCapacity = returnCapacity(ctx)
+	input.ScanIndexForward = aws.Bool(false)
+	input.Limit = aws.Int32(1)
+	for {
+		out, err := s.api.Query(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+		recordCapacity(ctx, out.ConsumedCapacity)
+		if len(out.Items) == 0 {
+			return 0, nil
+		}
 
-	recent := history[len(history)-len(records):]
-	fmt.Printf("equality: %t\n", reflect.DeepEqual(recent[0].Version, records[0].Version))
-	fmt.Printf("equality: %t\n", reflect.DeepEqual(recent[0].Data, records[0].Data))
-	if !reflect.DeepEqual(recent, History(records)) {
-		return errors.New(ConditionalCheckFailed)
+		records, err := s.decode(out.Items)
+		if err != nil {
+			return 0, err
+		}
+		newest := records[0]
+		if newest.BatchID == "" || newest.Version == newest.BatchLast {
+			return newest.Version, nil
+		}
+
+		// The newest record belongs to a pending batch, so the stream ends before the batch starts
+		input.KeyConditionExpression = aws.String("#key = :key AND #version < :first")
+		input.ExpressionAttributeValues[":first"] = &types.AttributeValueMemberN{Value: strconv.Itoa(newest.BatchFirst)}
 	}
-	return nil
+}
+
+func (s *DynamoDBStore) key(aggregateID string, version int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.hashKey:  &types.AttributeValueMemberS{Value: aggregateID},
+		s.rangeKey: &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+	}
+}
+
+// decode converts raw items into records, taking the version from the table's range key
+func (s *DynamoDBStore) decode(items []map[string]types.AttributeValue) ([]dynamoRecord, error) {
+	records := make([]dynamoRecord, 0, len(items))
+	for _, item := range items {
+		var record dynamoRecord
+		if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+			return nil, err
+		}
+		if err := attributevalue.Unmarshal(item[s.rangeKey], &record.Version); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
 }
@@ -0,0 +1,350 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Attributes written on every record of a batch that spans several transactions.
+// A batch is committed once its last record exists; until then its records are pending.
+const (
+	batchIDAttribute    = "batch_id"
+	batchFirstAttribute = "batch_first"
+	batchLastAttribute  = "batch_last"
+	batchAtAttribute    = "batch_at"
+)
+
+// DefaultBatchTimeout is how long ReadAll waits for a batch to commit before recovering it, see WithBatchTimeout
+const DefaultBatchTimeout = 5 * time.Minute
+
+// dynamoRecord is a Record as stored in DynamoDB, along with the bookkeeping of pending batches
+// and, when the global log is enabled, of its place in the log
+type dynamoRecord struct {
+	Record
+	BatchID     string `dynamodbav:"batch_id,omitempty"`
+	BatchFirst  int    `dynamodbav:"batch_first,omitempty"`
+	BatchLast   int    `dynamodbav:"batch_last,omitempty"`
+	BatchAt     int64  `dynamodbav:"batch_at,omitempty"`
+	Position    int64  `dynamodbav:"log_position,omitempty"`
+	AggregateID string `dynamodbav:"log_aggregate_id,omitempty"`
+	LogVersion  int    `dynamodbav:"log_version,omitempty"`
+}
+
+// pendingBatch describes a batch of records written over several transactions
+type pendingBatch struct {
+	id    string
+	first int
+	last  int
+	at    int64
+}
+
+// mark adds the batch attributes to a record update
+func (b *pendingBatch) mark(update *types.Update) {
+	update.UpdateExpression = aws.String(
+		aws.ToString(update.UpdateExpression) + ", #batch = :batch, #first = :first, #last = :last, #at = :at",
+	)
+	update.ExpressionAttributeNames["#batch"] = batchIDAttribute
+	update.ExpressionAttributeNames["#first"] = batchFirstAttribute
+	update.ExpressionAttributeNames["#last"] = batchLastAttribute
+	update.ExpressionAttributeNames["#at"] = batchAtAttribute
+	update.ExpressionAttributeValues[":batch"] = &types.AttributeValueMemberS{Value: b.id}
+	update.ExpressionAttributeValues[":first"] = &types.AttributeValueMemberN{Value: strconv.Itoa(b.first)}
+	update.ExpressionAttributeValues[":last"] = &types.AttributeValueMemberN{Value: strconv.Itoa(b.last)}
+	update.ExpressionAttributeValues[":at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(b.at, 10)}
+}
+
+// item returns the batch attributes for an item that is put rather than updated
+func (b *pendingBatch) item() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		batchIDAttribute:    &types.AttributeValueMemberS{Value: b.id},
+		batchFirstAttribute: &types.AttributeValueMemberN{Value: strconv.Itoa(b.first)},
+		batchLastAttribute:  &types.AttributeValueMemberN{Value: strconv.Itoa(b.last)},
+		batchAtAttribute:    &types.AttributeValueMemberN{Value: strconv.FormatInt(b.at, 10)},
+	}
+}
+
+// chunkRecords splits sorted records into groups that each fit in one transaction, where every record takes
+// itemsPerRecord items and as many items are kept free for the checks that guard every transaction
+func chunkRecords(records []Record, itemsPerRecord int) [][]Record {
+	maxRecords := (MaxTransactionItems - itemsPerRecord) / itemsPerRecord
+	var chunks [][]Record
+	start, size := 0, 0
+	for i, record := range records {
+		recordSize := (recordBytes(record) + itemOverheadBytes) * itemsPerRecord
+		if i > start && (i-start == maxRecords || size+recordSize > maxTransactionBytes) {
+			chunks = append(chunks, records[start:i])
+			start, size = i, 0
+		}
+		size += recordSize
+	}
+	return append(chunks, records[start:])
+}
+
+// recordBytes is the size of a record's data and metadata
+func recordBytes(record Record) int {
+	size := len(record.Data) + len(record.EventType) + len(record.EventID) + len(record.CorrelationID) + len(record.CausationID)
+	for k, v := range record.Headers {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// saveBatch writes the chunks one transaction at a time and rolls back what was written if a chunk fails
+func (s *DynamoDBStore) saveBatch(ctx context.Context, aggregateID string, expectedVersion int, chunks [][]Record) error {
+	lastChunk := chunks[len(chunks)-1]
+	batch := &pendingBatch{
+		id:    uuid.NewV4().String(),
+		first: chunks[0][0].Version,
+		last:  lastChunk[len(lastChunk)-1].Version,
+		at:    time.Now().UnixNano(),
+	}
+
+	for i, chunk := range chunks {
+		err := s.write(ctx, aggregateID, expectedVersion, batch, chunk)
+		if err == nil {
+			continue
+		}
+
+		if i == 0 {
+			if isConditionFailure(err) {
+				return s.conflict(ctx, aggregateID, expectedVersion)
+			}
+			return err
+		}
+
+		if rollbackErr := s.removeBatch(ctx, aggregateID, batch); rollbackErr != nil {
+			return fmt.Errorf("batch %s failed: %w; rollback failed, run Recover: %v", batch.id, err, rollbackErr)
+		}
+		return fmt.Errorf("batch %s failed and was rolled back: %w", batch.id, err)
+	}
+	return nil
+}
+
+// removeBatch looks up the records written so far for an uncommitted batch and deletes them
+func (s *DynamoDBStore) removeBatch(ctx context.Context, aggregateID string, batch *pendingBatch) error {
+	input := s.batchQuery(aggregateID)
+	input.KeyConditionExpression = aws.String("#key = :key AND #version >= :first")
+	input.ExpressionAttributeValues[":first"] = &types.AttributeValueMemberN{Value: strconv.Itoa(batch.first)}
+	items, err := s.query(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	var written []dynamoRecord
+	for _, item := range items {
+		if item.BatchID == batch.id {
+			written = append(written, item)
+		}
+	}
+	return s.deleteBatch(ctx, aggregateID, batch, written)
+}
+
+// batchQuery builds a Query over a stream that reads only what is needed to clean up batches
+func (s *DynamoDBStore) batchQuery(aggregateID string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#key = :key"),
+		ProjectionExpression:   aws.String("#version, #batch, #first, #last, #at, #position"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":      s.hashKey,
+			"#version":  s.rangeKey,
+			"#batch":    batchIDAttribute,
+			"#first":    batchFirstAttribute,
+			"#last":     batchLastAttribute,
+			"#at":       batchAtAttribute,
+			"#position": logPositionAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key": &types.AttributeValueMemberS{Value: aggregateID},
+		},
+	}
+}
+
+// deleteBatch removes the given records of an uncommitted batch, along with their entries in the global log
+// and their copies in the outbox.
+// The first transaction removes the batch's first record, which stops any further chunk of the batch from
+// landing, and it is conditioned on the last record being absent so a batch that committed meanwhile is kept.
+func (s *DynamoDBStore) deleteBatch(ctx context.Context, aggregateID string, batch *pendingBatch, items []dynamoRecord) error {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Version == batch.first || items[j].Version == batch.first {
+			return items[i].Version == batch.first
+		}
+		return items[i].Version > items[j].Version
+	})
+
+	deleteItem := func(tableName string, key map[string]types.AttributeValue) types.TransactWriteItem {
+		return types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName:           aws.String(tableName),
+				Key:                 key,
+				ConditionExpression: aws.String("attribute_not_exists(#version) OR #batch = :batch"),
+				ExpressionAttributeNames: map[string]string{
+					"#version": s.rangeKey,
+					"#batch":   batchIDAttribute,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":batch": &types.AttributeValueMemberS{Value: batch.id},
+				},
+			},
+		}
+	}
+
+	// The first record is deleted even when it is already gone, so the first transaction always guards the batch
+	deletes := []types.TransactWriteItem{deleteItem(s.tableName, s.key(aggregateID, batch.first))}
+	for _, item := range items {
+		if item.Version != batch.first {
+			deletes = append(deletes, deleteItem(s.tableName, s.key(aggregateID, item.Version)))
+		}
+		if item.Position > 0 {
+			deletes = append(deletes, deleteItem(s.tableName, s.logKey(item.Position)))
+		}
+		if s.outboxTableName != "" {
+			deletes = append(deletes, deleteItem(s.outboxTableName, s.key(aggregateID, item.Version)))
+		}
+	}
+
+	for start := 0; start < len(deletes); start += MaxTransactionItems - 1 {
+		end := start + MaxTransactionItems - 1
+		if end > len(deletes) {
+			end = len(deletes)
+		}
+
+		input := &dynamodb.TransactWriteItemsInput{}
+		if start == 0 {
+			input.TransactItems = append(input.TransactItems, types.TransactWriteItem{
+				ConditionCheck: &types.ConditionCheck{
+					TableName:           aws.String(s.tableName),
+					Key:                 s.key(aggregateID, batch.last),
+					ConditionExpression: aws.String("attribute_not_exists(#version)"),
+					ExpressionAttributeNames: map[string]string{
+						"#version": s.rangeKey,
+					},
+				},
+			})
+		}
+		input.TransactItems = append(input.TransactItems, deletes[start:end]...)
+
+		if err := s.transact(ctx, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recover removes the records of batches that were left half-written, for instance by a crashed writer.
+// Only batches started more than olderThan ago are removed, so writers still in progress are left alone.
+func (s *DynamoDBStore) Recover(ctx context.Context, aggregateID string, olderThan time.Duration) error {
+	if err := checkAggregateID(aggregateID); err != nil {
+		return err
+	}
+	items, err := s.query(ctx, s.batchQuery(aggregateID))
+	if err != nil {
+		return err
+	}
+
+	batches := map[string]*pendingBatch{}
+	written := map[string][]dynamoRecord{}
+	committed := map[string]bool{}
+	var order []string
+	for _, item := range items {
+		if item.BatchID == "" {
+			continue
+		}
+		if _, ok := batches[item.BatchID]; !ok {
+			batches[item.BatchID] = &pendingBatch{id: item.BatchID, first: item.BatchFirst, last: item.BatchLast, at: item.BatchAt}
+			order = append(order, item.BatchID)
+		}
+		written[item.BatchID] = append(written[item.BatchID], item)
+		if item.Version == item.BatchLast {
+			committed[item.BatchID] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-olderThan).UnixNano()
+	for _, id := range order {
+		batch := batches[id]
+		if committed[id] || batch.at > cutoff {
+			continue
+		}
+		if err = s.deleteBatch(ctx, aggregateID, batch, written[id]); err != nil {
+			return fmt.Errorf("unable to recover batch %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// visible turns the items read for a Load into History, cutting it short at the first record of a batch
+// that is not committed, or of which this read did not see every record in the requested range
+func (s *DynamoDBStore) visible(
+	ctx context.Context,
+	aggregateID string,
+	items []dynamoRecord,
+	fromVersion, toVersion int,
+) (History, error) {
+	seen := map[string]int{}
+	finalSeen := map[string]bool{}
+	for _, item := range items {
+		if item.BatchID == "" {
+			continue
+		}
+		seen[item.BatchID]++
+		if item.Version == item.BatchLast {
+			finalSeen[item.BatchID] = true
+		}
+	}
+
+	history := make(History, 0, len(items))
+	checked := map[string]bool{}
+	for _, item := range items {
+		if item.BatchID != "" && !checked[item.BatchID] {
+			ok, err := s.batchVisible(ctx, aggregateID, item, seen[item.BatchID], finalSeen[item.BatchID], fromVersion, toVersion)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			checked[item.BatchID] = true
+		}
+		history = append(history, item.Record)
+	}
+	return history, nil
+}
+
+func (s *DynamoDBStore) batchVisible(
+	ctx context.Context,
+	aggregateID string,
+	item dynamoRecord,
+	seen int,
+	finalSeen bool,
+	fromVersion, toVersion int,
+) (bool, error) {
+	from, to := item.BatchFirst, item.BatchLast
+	if fromVersion > from {
+		from = fromVersion
+	}
+	if toVersion > 0 && toVersion < to {
+		to = toVersion
+	}
+	if seen != to-from+1 {
+		return false, nil
+	}
+	if finalSeen {
+		return true, nil
+	}
+	if to == item.BatchLast {
+		return false, nil
+	}
+
+	// The requested range ends before the batch does, so look up the batch's last record directly
+	return s.batchCommitted(ctx, aggregateID, item.BatchID, item.BatchLast)
+}
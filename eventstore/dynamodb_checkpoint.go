@@ -0,0 +1,72 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// checkpointAttribute holds the sequence number of a checkpoint item
+const checkpointAttribute = "sequence_number"
+
+// DynamoDBCheckpointStore is a checkpoint store implementation using DynamoDB.
+// Its table has the same key schema as an event table: every checkpoint is an item keyed by the subscriber
+// and shard ids, at version 0. It must not be the table the subscriber reads the change feed of, or saving
+// checkpoints would feed the subscriber changes forever.
+type DynamoDBCheckpointStore struct {
+	tableName string
+	hashKey   string
+	rangeKey  string
+	api       *dynamodb.Client
+}
+
+// GetDynamoDBCheckpointStore returns a new DB checkpoint store instance
+func GetDynamoDBCheckpointStore(tableName, partitionKey, rangeKey string, db *dynamodb.Client) *DynamoDBCheckpointStore {
+	return &DynamoDBCheckpointStore{
+		tableName: tableName,
+		hashKey:   partitionKey,
+		rangeKey:  rangeKey,
+		api:       db,
+	}
+}
+
+func (s *DynamoDBCheckpointStore) key(subscriberID, shardID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.hashKey:  &types.AttributeValueMemberS{Value: checkpointID(subscriberID, shardID)},
+		s.rangeKey: &types.AttributeValueMemberN{Value: "0"},
+	}
+}
+
+// SaveCheckpoint implements the CheckpointStore interface
+func (s *DynamoDBCheckpointStore) SaveCheckpoint(ctx context.Context, subscriberID, shardID, sequenceNumber string) error {
+	item := s.key(subscriberID, shardID)
+	item[checkpointAttribute] = &types.AttributeValueMemberS{Value: sequenceNumber}
+	_, err := s.api.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+// LoadCheckpoint implements the CheckpointStore interface
+func (s *DynamoDBCheckpointStore) LoadCheckpoint(ctx context.Context, subscriberID, shardID string) (string, error) {
+	out, err := s.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            s.key(subscriberID, shardID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.Item == nil {
+		return "", nil
+	}
+	sequenceNumber, ok := out.Item[checkpointAttribute].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("malformed checkpoint of %s in shard %s", subscriberID, shardID)
+	}
+	return sequenceNumber.Value, nil
+}
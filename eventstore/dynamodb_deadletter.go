@@ -0,0 +1,121 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBDeadLetterStore is a dead letter store implementation using DynamoDB.
+// Its table has the same key schema as an event table: every dead letter is an item keyed by its id, at
+// version 0. Listing scans the whole table, so it must be a table of its own.
+type DynamoDBDeadLetterStore struct {
+	tableName string
+	hashKey   string
+	rangeKey  string
+	api       *dynamodb.Client
+}
+
+// GetDynamoDBDeadLetterStore returns a new DB dead letter store instance
+func GetDynamoDBDeadLetterStore(tableName, partitionKey, rangeKey string, db *dynamodb.Client) *DynamoDBDeadLetterStore {
+	return &DynamoDBDeadLetterStore{
+		tableName: tableName,
+		hashKey:   partitionKey,
+		rangeKey:  rangeKey,
+		api:       db,
+	}
+}
+
+func (s *DynamoDBDeadLetterStore) key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.hashKey:  &types.AttributeValueMemberS{Value: id},
+		s.rangeKey: &types.AttributeValueMemberN{Value: "0"},
+	}
+}
+
+// SaveDeadLetter implements the DeadLetterStore interface
+func (s *DynamoDBDeadLetterStore) SaveDeadLetter(ctx context.Context, letter DeadLetter) error {
+	item, err := attributevalue.MarshalMap(letter)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.key(letter.ID) {
+		item[k] = v
+	}
+	_, err = s.api.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+// ListDeadLetters implements the DeadLetterStore interface
+func (s *DynamoDBDeadLetterStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(s.tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	var letters []DeadLetter
+	for {
+		out, err := s.api.Scan(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			letter, decodeErr := s.decode(item)
+			if decodeErr != nil {
+				return nil, decodeErr
+			}
+			letters = append(letters, *letter)
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	sortDeadLetters(letters)
+	return letters, nil
+}
+
+// LoadDeadLetter implements the DeadLetterStore interface
+func (s *DynamoDBDeadLetterStore) LoadDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	out, err := s.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            s.key(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, deadLetterNotFound(id)
+	}
+	return s.decode(out.Item)
+}
+
+// DeleteDeadLetter implements the DeadLetterStore interface
+func (s *DynamoDBDeadLetterStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	_, err := s.api.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       s.key(id),
+	})
+	return err
+}
+
+// decode converts a raw item into a dead letter, taking the id from the table's hash key
+func (s *DynamoDBDeadLetterStore) decode(item map[string]types.AttributeValue) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := attributevalue.UnmarshalMap(item, &letter); err != nil {
+		return nil, err
+	}
+	id, ok := item[s.hashKey].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, fmt.Errorf("dead letter item without a %s", s.hashKey)
+	}
+	letter.ID = id.Value
+	return &letter, nil
+}
@@ -0,0 +1,297 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The global log is kept in the store's own table. Its entries live under a reserved aggregate id with the
+// position as range key, so ReadAll is a strongly consistent Query, which a GSI could not offer.
+// The item at position 0 holds the sequence counter; every transaction that appends records moves it forward,
+// conditioned on its previous value, which makes positions follow commit order.
+const (
+	globalLogID             = "$all"
+	logPositionAttribute    = "log_position"
+	logAggregateIDAttribute = "log_aggregate_id"
+	logVersionAttribute     = "log_version"
+)
+
+// maxLogAttempts bounds how often a write is retried because other writers moved the sequence counter first
+const maxLogAttempts = 25
+
+// errNoGlobalLog is returned by ReadAll on a store that was not created WithGlobalLog
+var errNoGlobalLog = errors.New("the global log is not enabled on this store")
+
+// checkAggregateID rejects the id the global log lives under, whether or not this store keeps the log, so that
+// the log can be turned on later
+func checkAggregateID(aggregateID string) error {
+	if aggregateID == globalLogID {
+		return fmt.Errorf("%w: %s", ErrReservedAggregateID, aggregateID)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) logKey(position int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.hashKey:  &types.AttributeValueMemberS{Value: globalLogID},
+		s.rangeKey: &types.AttributeValueMemberN{Value: strconv.FormatInt(position, 10)},
+	}
+}
+
+// logPosition reads the position of the last record appended to the global log
+func (s *DynamoDBStore) logPosition(ctx context.Context) (int64, error) {
+	out, err := s.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:              aws.String(s.tableName),
+		Key:                    s.logKey(0),
+		ConsistentRead:         aws.Bool(true),
+		ReturnConsumedCapacity: returnCapacity(ctx),
+	})
+	if err != nil {
+		return 0, err
+	}
+	recordCapacity(ctx, out.ConsumedCapacity)
+	counter, ok := out.Item[logPositionAttribute].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(counter.Value, 10, 64)
+}
+
+// writeLogged stores the records of the streams in a single transaction along with their global log entries
+func (s *DynamoDBStore) writeLogged(ctx context.Context, batch *pendingBatch, streams []Append) error {
+	for attempt := 1; ; attempt++ {
+		position, err := s.logPosition(ctx)
+		if err != nil {
+			return err
+		}
+
+		input := &dynamodb.TransactWriteItemsInput{}
+		next := position
+		for _, stream := range streams {
+			items := s.writeItems(stream.AggregateID, stream.ExpectedVersion, batch, stream.Records)
+			offset := len(items) - len(stream.Records)
+			for i := range stream.Records {
+				next++
+				update := items[offset+i].Update
+				update.UpdateExpression = aws.String(aws.ToString(update.UpdateExpression) + ", #position = :position")
+				update.ExpressionAttributeNames["#position"] = logPositionAttribute
+				update.ExpressionAttributeValues[":position"] = &types.AttributeValueMemberN{
+					Value: strconv.FormatInt(next, 10),
+				}
+			}
+			input.TransactItems = append(input.TransactItems, items...)
+		}
+
+		counterIndex := len(input.TransactItems)
+		input.TransactItems = append(input.TransactItems, s.counterUpdate(position, next))
+		next = position
+		for _, stream := range streams {
+			for _, record := range stream.Records {
+				next++
+				input.TransactItems = append(input.TransactItems, s.logEntry(stream.AggregateID, next, batch, record))
+			}
+		}
+		input.TransactItems = append(input.TransactItems, s.outboxStreams(batch, streams)...)
+
+		err = s.transact(ctx, input)
+		if err == nil || attempt == maxLogAttempts || !counterMoved(err, counterIndex) {
+			return err
+		}
+	}
+}
+
+// counterUpdate moves the sequence counter from one position to the next, provided nobody moved it meanwhile
+func (s *DynamoDBStore) counterUpdate(from, to int64) types.TransactWriteItem {
+	condition := "#position = :from"
+	if from == 0 {
+		condition = "attribute_not_exists(#position)"
+	}
+	values := map[string]types.AttributeValue{
+		":to": &types.AttributeValueMemberN{Value: strconv.FormatInt(to, 10)},
+	}
+	if from > 0 {
+		values[":from"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(from, 10)}
+	}
+
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:           aws.String(s.tableName),
+			Key:                 s.logKey(0),
+			UpdateExpression:    aws.String("set #position = :to"),
+			ConditionExpression: aws.String(condition),
+			ExpressionAttributeNames: map[string]string{
+				"#position": logPositionAttribute,
+			},
+			ExpressionAttributeValues: values,
+		},
+	}
+}
+
+// logEntry puts a copy of the record in the global log
+func (s *DynamoDBStore) logEntry(aggregateID string, position int64, batch *pendingBatch, record Record) types.TransactWriteItem {
+	item := s.logKey(position)
+	item[logAggregateIDAttribute] = &types.AttributeValueMemberS{Value: aggregateID}
+	item[logVersionAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(record.Version)}
+	item["event_data"] = &types.AttributeValueMemberB{Value: record.Data}
+	for k, v := range metadataAttributes(record) {
+		item[k] = v
+	}
+	if batch != nil {
+		for k, v := range batch.item() {
+			item[k] = v
+		}
+	}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(s.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#version)"),
+			ExpressionAttributeNames: map[string]string{
+				"#version": s.rangeKey,
+			},
+		},
+	}
+}
+
+// counterMoved reports whether a transaction failed only because the sequence counter was moved by another writer
+func counterMoved(err error, counterIndex int) bool {
+	var txnCanceled *types.TransactionCanceledException
+	if !errors.As(err, &txnCanceled) || len(txnCanceled.CancellationReasons) <= counterIndex {
+		return false
+	}
+	for _, reason := range txnCanceled.CancellationReasons[:counterIndex] {
+		if aws.ToString(reason.Code) == ConditionalCheckFailed {
+			return false
+		}
+	}
+	code := aws.ToString(txnCanceled.CancellationReasons[counterIndex].Code)
+	return code == ConditionalCheckFailed || code == TransactionConflict
+}
+
+// ReadAll implements the GlobalReader interface for stores created WithGlobalLog.
+// Records of a batch that has not committed yet end the read, so readers never skip past them, unless the batch
+// started longer than the batch timeout ago: its stream is then recovered and its records skipped.
+func (s *DynamoDBStore) ReadAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	if !s.globalLog {
+		return nil, errNoGlobalLog
+	}
+	if fromPosition < 1 {
+		fromPosition = 1
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#key = :key AND #position >= :from"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":      s.hashKey,
+			"#position": s.rangeKey,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key":  &types.AttributeValueMemberS{Value: globalLogID},
+			":from": &types.AttributeValueMemberN{Value: strconv.FormatInt(fromPosition, 10)},
+		},
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+
+	result := make([]GlobalRecord, 0, limit)
+	committed := map[string]bool{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		input.ReturnConsumedCapacity = returnCapacity(ctx)
+		out, err := s.api.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		recordCapacity(ctx, out.ConsumedCapacity)
+		entries, err := s.decode(out.Items)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.BatchID != "" {
+				ok, known := committed[entry.BatchID]
+				if !known {
+					if ok, err = s.logBatchCommitted(ctx, entry); err != nil {
+						return nil, err
+					}
+					committed[entry.BatchID] = ok
+				}
+				if !ok && !s.batchTimedOut(entry) {
+					return result, nil
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			// decode took the log entry's range key, its position, as the version
+			record := entry.Record
+			record.Version = entry.LogVersion
+			result = append(result, GlobalRecord{
+				Record:      record,
+				AggregateID: entry.AggregateID,
+				Position:    int64(entry.Version),
+			})
+			if limit > 0 && len(result) == limit {
+				return result, nil
+			}
+		}
+
+		if len(out.LastEvaluatedKey) == 0 {
+			return result, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// logBatchCommitted looks up whether the batch of a log entry committed. A batch that timed out is recovered
+// first, after which it only counts as committed if it committed before it could be rolled back.
+func (s *DynamoDBStore) logBatchCommitted(ctx context.Context, entry dynamoRecord) (bool, error) {
+	if !s.batchTimedOut(entry) {
+		return s.batchCommitted(ctx, entry.AggregateID, entry.BatchID, entry.BatchLast)
+	}
+	if err := s.Recover(ctx, entry.AggregateID, s.batchTimeout); err != nil {
+		return false, fmt.Errorf("unable to recover batch %s: %w", entry.BatchID, err)
+	}
+	return s.batchCommitted(ctx, entry.AggregateID, entry.BatchID, entry.BatchLast)
+}
+
+// batchTimedOut reports whether the batch of an entry started longer than the batch timeout ago
+func (s *DynamoDBStore) batchTimedOut(entry dynamoRecord) bool {
+	return s.batchTimeout > 0 && time.Since(time.Unix(0, entry.BatchAt)) > s.batchTimeout
+}
+
+// batchCommitted looks up whether the last record of a batch exists
+func (s *DynamoDBStore) batchCommitted(ctx context.Context, aggregateID, batchID string, lastVersion int) (bool, error) {
+	out, err := s.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:              aws.String(s.tableName),
+		Key:                    s.key(aggregateID, lastVersion),
+		ConsistentRead:         aws.Bool(true),
+		ReturnConsumedCapacity: returnCapacity(ctx),
+		ProjectionExpression:   aws.String("#batch"),
+		ExpressionAttributeNames: map[string]string{
+			"#batch": batchIDAttribute,
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to look up batch %s: %w", batchID, err)
+	}
+	recordCapacity(ctx, out.ConsumedCapacity)
+	last, ok := out.Item[batchIDAttribute].(*types.AttributeValueMemberS)
+	return ok && last.Value == batchID, nil
+}
@@ -0,0 +1,253 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultRelayInterval is how long an OutboxRelay waits between passes over the outbox
+const DefaultRelayInterval = time.Second
+
+// outboxScanPageSize is how many outbox items a relay reads per Scan page while looking for aggregates to drain
+const outboxScanPageSize = 100
+
+// WithOutbox makes Save put a copy of every record in the outbox table, in the same transaction as the record.
+// An OutboxRelay drains the outbox to a Publisher, so every saved record is published at least once.
+// The outbox table has the same key schema as the store's table.
+func WithOutbox(tableName string) DynamoDBOption {
+	return func(s *DynamoDBStore) {
+		s.outboxTableName = tableName
+	}
+}
+
+// outboxStreams puts a copy of each record of the streams in the outbox, when the outbox is enabled
+func (s *DynamoDBStore) outboxStreams(batch *pendingBatch, streams []Append) []types.TransactWriteItem {
+	if s.outboxTableName == "" {
+		return nil
+	}
+	var items []types.TransactWriteItem
+	for _, stream := range streams {
+		items = append(items, s.outboxItems(stream.AggregateID, batch, stream.Records)...)
+	}
+	return items
+}
+
+// outboxItems puts a copy of each record in the outbox
+func (s *DynamoDBStore) outboxItems(aggregateID string, batch *pendingBatch, records []Record) []types.TransactWriteItem {
+	items := make([]types.TransactWriteItem, 0, len(records))
+	for _, record := range records {
+		item := s.key(aggregateID, record.Version)
+		item["event_data"] = &types.AttributeValueMemberB{Value: record.Data}
+		for k, v := range metadataAttributes(record) {
+			item[k] = v
+		}
+		if batch != nil {
+			for k, v := range batch.item() {
+				item[k] = v
+			}
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(s.outboxTableName),
+				Item:      item,
+			},
+		})
+	}
+	return items
+}
+
+// Publisher delivers the records drained from an outbox, for instance to a message broker
+type Publisher interface {
+	// Publish delivers a record. A record is removed from the outbox once Publish returns nil;
+	// when Publish fails, the record and every later record of its aggregate are tried again on the next pass.
+	Publish(ctx context.Context, aggregateID string, record Record) error
+}
+
+// OutboxRelay drains the outbox of a DynamoDBStore to a Publisher.
+// Records of an aggregate are published in version order, provided a single relay drains each segment.
+type OutboxRelay struct {
+	store         *DynamoDBStore
+	publisher     Publisher
+	interval      time.Duration
+	segment       int32
+	totalSegments int32
+	onError       func(error)
+}
+
+// RelayOption configures optional behavior of an OutboxRelay
+type RelayOption func(*OutboxRelay)
+
+// WithRelayInterval sets how long Run waits between passes over the outbox
+func WithRelayInterval(interval time.Duration) RelayOption {
+	return func(r *OutboxRelay) {
+		r.interval = interval
+	}
+}
+
+// WithRelaySegment makes the relay drain one of totalSegments segments of the outbox, so several relays can
+// share the work. All records of an aggregate fall in the same segment.
+func WithRelaySegment(segment, totalSegments int32) RelayOption {
+	return func(r *OutboxRelay) {
+		r.segment = segment
+		r.totalSegments = totalSegments
+	}
+}
+
+// WithRelayErrorHandler sets a function that Run calls with the errors of its passes
+func WithRelayErrorHandler(onError func(error)) RelayOption {
+	return func(r *OutboxRelay) {
+		r.onError = onError
+	}
+}
+
+// NewOutboxRelay returns a relay for the outbox of a store created WithOutbox
+func NewOutboxRelay(store *DynamoDBStore, publisher Publisher, opts ...RelayOption) (*OutboxRelay, error) {
+	if store.outboxTableName == "" {
+		return nil, fmt.Errorf("the outbox is not enabled on the store for table %s", store.tableName)
+	}
+	relay := &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		interval:  DefaultRelayInterval,
+	}
+	for _, opt := range opts {
+		opt(relay)
+	}
+	return relay, nil
+}
+
+// Run drains the outbox over and over until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Drain(ctx); err != nil && ctx.Err() == nil && r.onError != nil {
+			r.onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain makes one pass over the outbox and returns how many records it published.
+// It Scans the outbox a page at a time for the aggregates that have records in it, reading only their keys,
+// then Queries the records of each aggregate, which come in version order. Records of a batch that has not
+// committed yet hold back the rest of their aggregate.
+func (r *OutboxRelay) Drain(ctx context.Context) (int, error) {
+	s := r.store
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(s.outboxTableName),
+		Limit:                aws.Int32(outboxScanPageSize),
+		ProjectionExpression: aws.String("#key"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": s.hashKey,
+		},
+	}
+	if r.totalSegments > 1 {
+		input.Segment = aws.Int32(r.segment)
+		input.TotalSegments = aws.Int32(r.totalSegments)
+	}
+
+	published := 0
+	var firstErr error
+	drained := map[string]bool{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+
+		out, err := s.api.Scan(ctx, input)
+		if err != nil {
+			return published, err
+		}
+
+		for _, item := range out.Items {
+			key, ok := item[s.hashKey].(*types.AttributeValueMemberS)
+			if !ok {
+				return published, fmt.Errorf("outbox item without a %s", s.hashKey)
+			}
+			if drained[key.Value] {
+				continue
+			}
+			drained[key.Value] = true
+
+			count, err := r.drainAggregate(ctx, key.Value, &firstErr)
+			published += count
+			if err != nil {
+				return published, err
+			}
+		}
+
+		if len(out.LastEvaluatedKey) == 0 {
+			return published, firstErr
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// drainAggregate publishes the records of an aggregate in the outbox in version order, until one belongs to a
+// batch that has not committed yet or fails to publish, in which case the failure goes to publishErr unless it
+// already holds one. It returns how many records it published.
+func (r *OutboxRelay) drainAggregate(ctx context.Context, aggregateID string, publishErr *error) (int, error) {
+	s := r.store
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.outboxTableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#key = :key"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": s.hashKey,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key": &types.AttributeValueMemberS{Value: aggregateID},
+		},
+	}
+	if s.pageSize > 0 {
+		input.Limit = aws.Int32(s.pageSize)
+	}
+	records, err := s.query(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	committed := map[string]bool{}
+	for _, record := range records {
+		if record.BatchID != "" {
+			ok, known := committed[record.BatchID]
+			if !known {
+				if ok, err = s.batchCommitted(ctx, aggregateID, record.BatchID, record.BatchLast); err != nil {
+					return published, err
+				}
+				committed[record.BatchID] = ok
+			}
+			if !ok {
+				return published, nil
+			}
+		}
+
+		if err = r.publisher.Publish(ctx, aggregateID, record.Record); err != nil {
+			if *publishErr == nil {
+				*publishErr = fmt.Errorf("unable to publish version %d of %s: %w", record.Version, aggregateID, err)
+			}
+			return published, nil
+		}
+
+		_, err = s.api.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.outboxTableName),
+			Key:       s.key(aggregateID, record.Version),
+		})
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
@@ -0,0 +1,107 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attributes of a snapshot item besides its keys
+const (
+	snapshotVersionAttribute = "snapshot_version"
+	snapshotDataAttribute    = "snapshot_data"
+	snapshotAtAttribute      = "snapshot_at"
+)
+
+// DynamoDBSnapshotStore is a snapshot store implementation using DynamoDB.
+// Its table has the same key schema as an event table, but must be a table of its own: each aggregate has a
+// single item, at range key 0, which every newer snapshot overwrites, so older snapshots take no space.
+type DynamoDBSnapshotStore struct {
+	tableName string
+	hashKey   string
+	rangeKey  string
+	api       *dynamodb.Client
+}
+
+// GetDynamoDBSnapshotStore returns a new DB snapshot store instance
+func GetDynamoDBSnapshotStore(tableName, partitionKey, rangeKey string, db *dynamodb.Client) *DynamoDBSnapshotStore {
+	return &DynamoDBSnapshotStore{
+		tableName: tableName,
+		hashKey:   partitionKey,
+		rangeKey:  rangeKey,
+		api:       db,
+	}
+}
+
+func (s *DynamoDBSnapshotStore) key(aggregateID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.hashKey:  &types.AttributeValueMemberS{Value: aggregateID},
+		s.rangeKey: &types.AttributeValueMemberN{Value: "0"},
+	}
+}
+
+// SaveSnapshot implements the SnapshotStore interface. It replaces the snapshot of the aggregate unless that
+// one was taken at the same or a later version.
+func (s *DynamoDBSnapshotStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	item := s.key(snapshot.AggregateID)
+	item[snapshotVersionAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(snapshot.Version)}
+	item[snapshotDataAttribute] = &types.AttributeValueMemberB{Value: snapshot.Data}
+	item[snapshotAtAttribute] = &types.AttributeValueMemberN{Value: strconv.FormatInt(snapshot.At.UnixNano(), 10)}
+
+	_, err := s.api.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#version) OR #version < :version"),
+		ExpressionAttributeNames: map[string]string{
+			"#version": snapshotVersionAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": item[snapshotVersionAttribute],
+		},
+	})
+	var newer *types.ConditionalCheckFailedException
+	if errors.As(err, &newer) {
+		return nil
+	}
+	return err
+}
+
+// LoadSnapshot implements the SnapshotStore interface
+func (s *DynamoDBSnapshotStore) LoadSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error) {
+	out, err := s.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            s.key(aggregateID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	item := out.Item
+	version, versionOK := item[snapshotVersionAttribute].(*types.AttributeValueMemberN)
+	data, dataOK := item[snapshotDataAttribute].(*types.AttributeValueMemberB)
+	at, atOK := item[snapshotAtAttribute].(*types.AttributeValueMemberN)
+	if !versionOK || !dataOK || !atOK {
+		return nil, fmt.Errorf("malformed snapshot of aggregate %s", aggregateID)
+	}
+
+	snapshot := &Snapshot{AggregateID: aggregateID, Data: data.Value}
+	if snapshot.Version, err = strconv.Atoi(version.Value); err != nil {
+		return nil, err
+	}
+	nanos, err := strconv.ParseInt(at.Value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.At = time.Unix(0, nanos)
+	return snapshot, nil
+}
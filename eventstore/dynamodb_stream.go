@@ -0,0 +1,436 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// DefaultStreamPollInterval is how long a StreamSubscriber waits between passes over the stream
+const DefaultStreamPollInterval = time.Second
+
+// RecordHandler handles the records a StreamSubscriber reads from the change feed of a store
+type RecordHandler interface {
+	// HandleRecord handles a record committed to the store. The records of an aggregate come in version order.
+	// When it fails, the subscriber stops reading the record's shard and hands it the record again on its next
+	// pass, along with any record of the shard it handled since the last checkpoint.
+	HandleRecord(ctx context.Context, aggregateID string, record Record) error
+}
+
+// RecordHandlerFunc lets a function be a RecordHandler
+type RecordHandlerFunc func(ctx context.Context, aggregateID string, record Record) error
+
+// HandleRecord implements the RecordHandler interface
+func (f RecordHandlerFunc) HandleRecord(ctx context.Context, aggregateID string, record Record) error {
+	return f(ctx, aggregateID, record)
+}
+
+// StreamSubscriber reads the DynamoDB Stream of a store's table and hands the records committed to the store
+// to a RecordHandler. The table's stream must include new images.
+// It reads a shard only once it has read the shard's parent to the end, and the records of an aggregate
+// follow one another in a shard lineage, so they reach the handler in version order. Records are handled at
+// least once: after a failure or a restart, the subscriber reads a shard again from its last checkpoint.
+// The records of a batch reach the handler once the batch commits. A StreamSubscriber is not safe for
+// concurrent use, and each subscriber id must be used by a single subscriber at a time.
+type StreamSubscriber struct {
+	store        *DynamoDBStore
+	streams      *dynamodbstreams.Client
+	checkpoints  CheckpointStore
+	subscriberID string
+	handler      RecordHandler
+	interval     time.Duration
+	onError      func(error)
+
+	streamArn string
+	readers   map[string]*shardReader
+	finished  map[string]bool
+}
+
+// SubscriberOption configures optional behavior of a StreamSubscriber
+type SubscriberOption func(*StreamSubscriber)
+
+// WithStreamPollInterval sets how long Run waits between passes over the stream
+func WithStreamPollInterval(interval time.Duration) SubscriberOption {
+	return func(s *StreamSubscriber) {
+		s.interval = interval
+	}
+}
+
+// WithStreamErrorHandler sets a function that Run calls with the errors of its passes
+func WithStreamErrorHandler(onError func(error)) SubscriberOption {
+	return func(s *StreamSubscriber) {
+		s.onError = onError
+	}
+}
+
+// NewStreamSubscriber returns a subscriber to the stream of the store's table, which keeps its checkpoints
+// under subscriberID
+func NewStreamSubscriber(
+	store *DynamoDBStore,
+	streams *dynamodbstreams.Client,
+	checkpoints CheckpointStore,
+	subscriberID string,
+	handler RecordHandler,
+	opts ...SubscriberOption,
+) *StreamSubscriber {
+	subscriber := &StreamSubscriber{
+		store:        store,
+		streams:      streams,
+		checkpoints:  checkpoints,
+		subscriberID: subscriberID,
+		handler:      handler,
+		interval:     DefaultStreamPollInterval,
+		readers:      map[string]*shardReader{},
+		finished:     map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(subscriber)
+	}
+	return subscriber
+}
+
+// shardReader is where a subscriber is in an open shard
+type shardReader struct {
+	shardID  string
+	iterator string
+	// checkpoint is the sequence number up to which every record was handled
+	checkpoint string
+	saved      string
+	// held has the records of batches that have not committed yet, by batch id
+	held map[string][]heldRecord
+}
+
+// heldRecord is a record of a batch that has not committed yet
+type heldRecord struct {
+	aggregateID string
+	record      Record
+}
+
+// Run reads the stream over and over until ctx is done
+func (s *StreamSubscriber) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Drain(ctx); err != nil && ctx.Err() == nil && s.onError != nil {
+			s.onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain makes one pass over the stream, reading each shard as far as it goes, and returns how many records
+// it handed to the handler
+func (s *StreamSubscriber) Drain(ctx context.Context) (int, error) {
+	if s.streamArn == "" {
+		streamArn, err := s.latestStreamArn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.streamArn = streamArn
+	}
+
+	shards, err := s.listShards(ctx)
+	if err != nil {
+		return 0, err
+	}
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.ToString(shard.ShardId)] = true
+	}
+	for shardID := range s.finished {
+		if !listed[shardID] {
+			delete(s.finished, shardID)
+		}
+	}
+
+	handled := 0
+	var firstErr error
+	read := map[string]bool{}
+	for progress := true; progress; {
+		progress = false
+		for _, shard := range shards {
+			shardID := aws.ToString(shard.ShardId)
+			if read[shardID] || s.finished[shardID] {
+				continue
+			}
+			// A parent that expired from the stream was read to the end long ago, or will never be
+			if parentID := aws.ToString(shard.ParentShardId); listed[parentID] && !s.finished[parentID] {
+				continue
+			}
+
+			read[shardID] = true
+			progress = true
+			n, err := s.drainShard(ctx, shardID)
+			handled += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return handled, firstErr
+}
+
+// latestStreamArn looks up the stream of the store's table
+func (s *StreamSubscriber) latestStreamArn(ctx context.Context) (string, error) {
+	out, err := s.store.api.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.store.tableName),
+	})
+	if err != nil {
+		return "", err
+	}
+	spec := out.Table.StreamSpecification
+	if out.Table.LatestStreamArn == nil || spec == nil || !aws.ToBool(spec.StreamEnabled) {
+		return "", fmt.Errorf("table %s has no stream", s.store.tableName)
+	}
+	if spec.StreamViewType != types.StreamViewTypeNewImage && spec.StreamViewType != types.StreamViewTypeNewAndOldImages {
+		return "", fmt.Errorf("the stream of table %s does not include new images", s.store.tableName)
+	}
+	return aws.ToString(out.Table.LatestStreamArn), nil
+}
+
+// listShards lists every shard of the stream
+func (s *StreamSubscriber) listShards(ctx context.Context) ([]streamtypes.Shard, error) {
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(s.streamArn)}
+	var shards []streamtypes.Shard
+	for {
+		out, err := s.streams.DescribeStream(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// drainShard reads a shard until it has no more records for now, or until it ends
+func (s *StreamSubscriber) drainShard(ctx context.Context, shardID string) (int, error) {
+	reader, ok := s.readers[shardID]
+	if !ok {
+		var err error
+		if reader, err = s.openShard(ctx, shardID); err != nil {
+			return 0, err
+		}
+		s.readers[shardID] = reader
+	}
+
+	handled := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return handled, err
+		}
+
+		out, err := s.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: aws.String(reader.iterator)})
+		if err != nil {
+			delete(s.readers, shardID)
+			var expired *streamtypes.ExpiredIteratorException
+			if errors.As(err, &expired) {
+				// The next pass starts over from the checkpoint with a new iterator
+				return handled, nil
+			}
+			return handled, err
+		}
+
+		for _, record := range out.Records {
+			n, err := s.handle(ctx, reader, record)
+			handled += n
+			if err != nil {
+				delete(s.readers, shardID)
+				if saveErr := s.saveCheckpoint(ctx, reader); saveErr != nil {
+					return handled, saveErr
+				}
+				return handled, err
+			}
+		}
+		if err = s.saveCheckpoint(ctx, reader); err != nil {
+			return handled, err
+		}
+
+		if out.NextShardIterator == nil {
+			delete(s.readers, shardID)
+			s.finished[shardID] = true
+			return handled, nil
+		}
+		reader.iterator = aws.ToString(out.NextShardIterator)
+		if len(out.Records) == 0 {
+			return handled, nil
+		}
+	}
+}
+
+// openShard starts reading a shard after its checkpoint, or from its oldest record without one.
+// A checkpoint older than the stream's retention restarts from the oldest record still in the shard.
+func (s *StreamSubscriber) openShard(ctx context.Context, shardID string) (*shardReader, error) {
+	checkpoint, err := s.checkpoints.LoadCheckpoint(ctx, s.subscriberID, shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(s.streamArn),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: streamtypes.ShardIteratorTypeTrimHorizon,
+	}
+	if checkpoint != "" {
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeAfterSequenceNumber
+		input.SequenceNumber = aws.String(checkpoint)
+	}
+	out, err := s.streams.GetShardIterator(ctx, input)
+	var trimmed *streamtypes.TrimmedDataAccessException
+	if errors.As(err, &trimmed) {
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeTrimHorizon
+		input.SequenceNumber = nil
+		out, err = s.streams.GetShardIterator(ctx, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &shardReader{
+		shardID:    shardID,
+		iterator:   aws.ToString(out.ShardIterator),
+		checkpoint: checkpoint,
+		saved:      checkpoint,
+		held:       map[string][]heldRecord{},
+	}, nil
+}
+
+// handle hands the record of a stream record to the handler, unless it belongs to a batch that has not
+// committed yet, and returns how many records it handed over.
+// The checkpoint only moves past a stream record when no earlier record is held back.
+func (s *StreamSubscriber) handle(ctx context.Context, reader *shardReader, change streamtypes.Record) (int, error) {
+	if change.Dynamodb == nil {
+		return 0, nil
+	}
+	handled := 0
+	switch change.EventName {
+	case streamtypes.OperationTypeInsert:
+		item := fromStreamImage(change.Dynamodb.NewImage)
+		key, ok := item[s.store.hashKey].(*types.AttributeValueMemberS)
+		if !ok || key.Value == globalLogID {
+			break
+		}
+		records, err := s.store.decode([]map[string]types.AttributeValue{item})
+		if err != nil {
+			return 0, err
+		}
+		record := records[0]
+
+		if record.BatchID != "" && record.Version != record.BatchLast {
+			reader.held[record.BatchID] = append(reader.held[record.BatchID], heldRecord{key.Value, record.Record})
+			return 0, nil
+		}
+		if record.BatchID != "" {
+			for _, held := range reader.held[record.BatchID] {
+				if err = s.handler.HandleRecord(ctx, held.aggregateID, held.record); err != nil {
+					return handled, s.handlerError(held.aggregateID, held.record, err)
+				}
+				handled++
+			}
+			delete(reader.held, record.BatchID)
+		}
+		if err = s.handler.HandleRecord(ctx, key.Value, record.Record); err != nil {
+			return handled, s.handlerError(key.Value, record.Record, err)
+		}
+		handled++
+
+	case streamtypes.OperationTypeRemove:
+		// Recover removes the records of a batch that never committed, so they are never handed over
+		keys := fromStreamImage(change.Dynamodb.Keys)
+		records, err := s.store.decode([]map[string]types.AttributeValue{keys})
+		if err != nil {
+			return 0, err
+		}
+		key, _ := keys[s.store.hashKey].(*types.AttributeValueMemberS)
+		for batchID, held := range reader.held {
+			kept := held[:0]
+			for _, h := range held {
+				if key == nil || h.aggregateID != key.Value || h.record.Version != records[0].Version {
+					kept = append(kept, h)
+				}
+			}
+			if len(kept) == 0 {
+				delete(reader.held, batchID)
+			} else {
+				reader.held[batchID] = kept
+			}
+		}
+	}
+
+	if len(reader.held) == 0 {
+		reader.checkpoint = aws.ToString(change.Dynamodb.SequenceNumber)
+	}
+	return handled, nil
+}
+
+func (s *StreamSubscriber) handlerError(aggregateID string, record Record, err error) error {
+	return fmt.Errorf("unable to handle version %d of %s: %w", record.Version, aggregateID, err)
+}
+
+// saveCheckpoint saves the reader's checkpoint when it moved since it was last saved
+func (s *StreamSubscriber) saveCheckpoint(ctx context.Context, reader *shardReader) error {
+	if reader.checkpoint == reader.saved {
+		return nil
+	}
+	if err := s.checkpoints.SaveCheckpoint(ctx, s.subscriberID, reader.shardID, reader.checkpoint); err != nil {
+		return err
+	}
+	reader.saved = reader.checkpoint
+	return nil
+}
+
+// fromStreamImage converts an item image of a stream record to the attribute values of the DynamoDB API
+func fromStreamImage(image map[string]streamtypes.AttributeValue) map[string]types.AttributeValue {
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		if converted := fromStreamAttribute(value); converted != nil {
+			item[name] = converted
+		}
+	}
+	return item
+}
+
+func fromStreamAttribute(value streamtypes.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &types.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: fromStreamImage(v.Value)}
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]types.AttributeValue, 0, len(v.Value))
+		for _, element := range v.Value {
+			if converted := fromStreamAttribute(element); converted != nil {
+				list = append(list, converted)
+			}
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	}
+	return nil
+}
@@ -1,14 +1,23 @@
 package eventstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 
 	"github.com/cannahum/eventsourcing-lite/utils/testutils"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const hashKey = "todo_id"
@@ -27,10 +36,10 @@ func TestGetDynamoDBStore(t *testing.T) {
 	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db)
 	ctx := context.Background()
 
-	t.Run("test Load function", func(ct *testing.T) {
+	t.Run("test Load of an unknown aggregate (error)", func(ct *testing.T) {
 		result, err := s.Load(ctx, "agg-id", 0, 0)
-		assert.Nil(t, err)
-		assert.Equal(t, History{}, result)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+		assert.Nil(ct, result)
 	})
 
 	t.Run("test Save function", func(ct *testing.T) {
@@ -43,7 +52,7 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		err := s.Save(ctx, aggID, records...)
+		err := s.Save(ctx, aggID, 0, records...)
 		assert.Nil(ct, err)
 	})
 
@@ -64,23 +73,83 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		err := s.Save(ctx, aggID, records...)
+		err := s.Save(ctx, aggID, 0, records...)
 		assert.Nil(ct, err)
 
-		err2 := s.Save(ctx, aggID, records...)
+		err2 := s.Save(ctx, aggID, 0, records...)
 		assert.NotNil(ct, err2)
 
-		err3 := s.Save(ctx, aggID, competingRecords...)
+		err3 := s.Save(ctx, aggID, 0, competingRecords...)
 		assert.NotNil(ct, err3)
 
 		competingRecords = append(competingRecords, Record{
 			Version: 2,
 			Data:    []byte("new data"),
 		})
-		err4 := s.Save(ctx, aggID, competingRecords...)
+		err4 := s.Save(ctx, aggID, 0, competingRecords...)
 		assert.NotNil(ct, err4)
 	})
 
+	t.Run("test Save with stale expected version (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		records := []Record{
+			{
+				Version: 1,
+				Data:    []byte("first data"),
+			},
+			{
+				Version: 2,
+				Data:    []byte("second data"),
+			},
+		}
+
+		err := s.Save(ctx, aggID, 0, records...)
+		assert.Nil(ct, err)
+
+		err = s.Save(ctx, aggID, 1, Record{Version: 2, Data: []byte("competing data")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, aggID, conflict.AggregateID)
+		assert.Equal(ct, 1, conflict.ExpectedVersion)
+		assert.Equal(ct, 2, conflict.ActualVersion)
+
+		readVersion, _ := s.Load(ctx, aggID, 0, 0)
+		assert.Equal(ct, History(records), readVersion)
+	})
+
+	t.Run("test Save with expected version ahead of the stream (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+
+		err := s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first data")})
+		assert.Nil(ct, err)
+
+		err = s.Save(ctx, aggID, 3, Record{Version: 4, Data: []byte("fourth data")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 3, conflict.ExpectedVersion)
+		assert.Equal(ct, 1, conflict.ActualVersion)
+	})
+
+	t.Run("test Save - records with a gap (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		records := []Record{
+			{
+				Version: 1,
+				Data:    []byte("first data"),
+			},
+			{
+				Version: 3,
+				Data:    []byte("third data"),
+			},
+		}
+
+		err := s.Save(ctx, aggID, 0, records...)
+		assert.NotNil(ct, err)
+
+		result, _ := s.Load(ctx, aggID, 0, 0)
+		assert.Equal(ct, History{}, result)
+	})
+
 	t.Run("test Save -> Load", func(ct *testing.T) {
 		aggID := uuid.NewV4().String()
 		// Create a few records
@@ -91,39 +160,96 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		_ = s.Save(ctx, aggID, records...)
+		_ = s.Save(ctx, aggID, 0, records...)
 
 		readVersion, err := s.Load(ctx, aggID, 0, 0)
 		assert.Nil(ct, err)
 		assert.Equal(ct, History(records), readVersion)
 	})
 
+	t.Run("test Save -> Load with metadata", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		occurredAt := time.Now()
+		record := Record{
+			Version:       1,
+			Data:          []byte("with metadata"),
+			EventType:     "TodoCreated",
+			EventID:       uuid.NewV4().String(),
+			OccurredAt:    occurredAt,
+			CorrelationID: "request-1",
+			CausationID:   "command-1",
+			Headers:       map[string]string{"tenant": "acme"},
+		}
+		err := s.Save(ctx, aggID, 0, record)
+		assert.Nil(ct, err)
+
+		result, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 1)
+		assert.True(ct, occurredAt.Equal(result[0].OccurredAt))
+		result[0].OccurredAt = occurredAt
+		assert.Equal(ct, record, result[0])
+	})
+
 	t.Run("test Save nothing", func(ct *testing.T) {
 		aggID := uuid.NewV4().String()
 		// Create a few records
 		var records []Record
 
-		err := s.Save(ctx, aggID, records...)
+		err := s.Save(ctx, aggID, 0, records...)
 		assert.Nil(ct, err)
 
 		result, _ := s.Load(ctx, aggID, 0, 0)
 		assert.Equal(t, History{}, result)
 	})
 
-	t.Run("test Save - over 25 items not permitted (error)", func(ct *testing.T) {
+	t.Run("test Save -> Load more items than fit in one transaction", func(ct *testing.T) {
 		aggID := uuid.NewV4().String()
-		// Create a few records
+		// Create enough records for three transactions
 		var records []Record
 
-		for i := 0; i < 30; i++ {
+		for i := 0; i < 2*MaxTransactionItems+30; i++ {
 			records = append(records, Record{
 				Version: i + 1,
 				Data:    []byte("some data"),
 			})
 		}
 
-		err := s.Save(ctx, aggID, records...)
-		assert.NotNil(ct, err)
+		err := s.Save(ctx, aggID, 0, records...)
+		assert.Nil(ct, err)
+
+		readVersion, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records), readVersion)
+
+		partial, err := s.Load(ctx, aggID, 10, 150)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records[9:150]), partial)
+
+		err = s.Save(ctx, aggID, len(records), Record{Version: len(records) + 1, Data: []byte("after the batch")})
+		assert.Nil(ct, err)
+	})
+
+	t.Run("test Save - batch over several transactions on a stale version (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		err := s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first data")})
+		assert.Nil(ct, err)
+
+		var records []Record
+		for i := 0; i < MaxTransactionItems+10; i++ {
+			records = append(records, Record{
+				Version: i + 1,
+				Data:    []byte("some data"),
+			})
+		}
+
+		err = s.Save(ctx, aggID, 0, records...)
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 1, conflict.ActualVersion)
+
+		readVersion, _ := s.Load(ctx, aggID, 0, 0)
+		assert.Len(ct, readVersion, 1)
 	})
 
 	t.Run("test Save - items with duplicate version (error)", func(ct *testing.T) {
@@ -140,7 +266,7 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		err := s.Save(ctx, aggID, records...)
+		err := s.Save(ctx, aggID, 0, records...)
 		assert.NotNil(ct, err)
 	})
 
@@ -162,7 +288,7 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		_ = s.Save(ctx, aggID, records...)
+		_ = s.Save(ctx, aggID, 0, records...)
 
 		readVersion, err := s.Load(ctx, aggID, 0, 0)
 		assert.Nil(ct, err)
@@ -195,7 +321,7 @@ func TestGetDynamoDBStore(t *testing.T) {
 			},
 		}
 
-		_ = s.Save(ctx, aggID, records...)
+		_ = s.Save(ctx, aggID, 0, records...)
 
 		readVersion, err := s.Load(ctx, aggID, 0, 0)
 		assert.Nil(ct, err)
@@ -209,4 +335,694 @@ func TestGetDynamoDBStore(t *testing.T) {
 		assert.Nil(ct, err)
 		assert.Equal(ct, History(records[2:]), thirdOnwards)
 	})
+
+	t.Run("test Load past the end of a stream", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		_ = s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first data")})
+
+		result, err := s.Load(ctx, aggID, 2, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 0)
+
+		_, err = s.Load(ctx, uuid.NewV4().String(), 2, 0)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+	})
+}
+
+func TestDynamoDBStoreLoadPagination(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	ctx := context.Background()
+	aggID := uuid.NewV4().String()
+
+	// 20 records of ~300 KB make a history of ~6 MB; a single Query page stops at 1 MB
+	const recordCount = 20
+	const recordSize = 300 * 1024
+	const recordsPerSave = 10
+	var records []Record
+	for i := 0; i < recordCount; i++ {
+		records = append(records, Record{
+			Version: i + 1,
+			Data:    bytes.Repeat([]byte{byte('a' + i)}, recordSize),
+		})
+	}
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db)
+	for i := 0; i < recordCount; i += recordsPerSave {
+		err := s.Save(ctx, aggID, i, records[i:i+recordsPerSave]...)
+		assert.Nil(t, err)
+	}
+
+	t.Run("test Load follows every page", func(ct *testing.T) {
+		result, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records), result)
+	})
+
+	t.Run("test Load partial range across pages", func(ct *testing.T) {
+		result, err := s.Load(ctx, aggID, 3, 17)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records[2:17]), result)
+	})
+
+	t.Run("test Load with a page size hint", func(ct *testing.T) {
+		paged := GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithPageSize(3))
+		result, err := paged.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records), result)
+	})
+
+	t.Run("test Load with a cancelled context (error)", func(ct *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		result, err := s.Load(cancelled, aggID, 0, 0)
+		assert.ErrorIs(ct, err, context.Canceled)
+		assert.Nil(ct, result)
+	})
+}
+
+func TestDynamoDBStoreRecover(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db)
+	ctx := context.Background()
+
+	// writeHalfBatch simulates a writer that crashed after the first chunk of a batch ending at version last
+	writeHalfBatch := func(aggID string, expectedVersion, last int, at time.Time) []Record {
+		var records []Record
+		for v := expectedVersion + 1; v < last; v++ {
+			records = append(records, Record{Version: v, Data: []byte("pending data")})
+		}
+		batch := &pendingBatch{id: uuid.NewV4().String(), first: expectedVersion + 1, last: last, at: at.UnixNano()}
+		err := s.write(ctx, aggID, expectedVersion, batch, records)
+		assert.Nil(t, err)
+		return records
+	}
+
+	t.Run("test Load hides a half-written batch", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		committed := []Record{{Version: 1, Data: []byte("first data")}}
+		_ = s.Save(ctx, aggID, 0, committed...)
+		writeHalfBatch(aggID, 1, 10, time.Now())
+
+		result, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(committed), result)
+
+		result, err = s.Load(ctx, aggID, 0, 5)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(committed), result)
+	})
+
+	t.Run("test a half-written batch does not make a stream", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		writeHalfBatch(aggID, 0, 10, time.Now())
+
+		version, err := s.currentVersion(ctx, aggID)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 0, version)
+
+		_, err = s.Load(ctx, aggID, 2, 0)
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+	})
+
+	t.Run("test Recover removes a stale half-written batch", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		committed := []Record{{Version: 1, Data: []byte("first data")}}
+		_ = s.Save(ctx, aggID, 0, committed...)
+		writeHalfBatch(aggID, 1, 10, time.Now().Add(-time.Hour))
+
+		// The pending records block the stream until the batch is recovered
+		err := s.Save(ctx, aggID, 1, Record{Version: 2, Data: []byte("second data")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 1, conflict.ActualVersion)
+
+		err = s.Recover(ctx, aggID, time.Minute)
+		assert.Nil(ct, err)
+
+		err = s.Save(ctx, aggID, 1, Record{Version: 2, Data: []byte("second data")})
+		assert.Nil(ct, err)
+
+		result, _ := s.Load(ctx, aggID, 0, 0)
+		assert.Len(ct, result, 2)
+	})
+
+	t.Run("test Recover leaves a recent batch alone", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		pending := writeHalfBatch(aggID, 0, 10, time.Now())
+
+		err := s.Recover(ctx, aggID, time.Minute)
+		assert.Nil(ct, err)
+
+		// The writer can still finish its batch
+		batchItems, _ := s.query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			ConsistentRead:         aws.Bool(true),
+			KeyConditionExpression: aws.String("#key = :key"),
+			ExpressionAttributeNames: map[string]string{
+				"#key": hashKey,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":key": &types.AttributeValueMemberS{Value: aggID},
+			},
+		})
+		assert.Len(ct, batchItems, len(pending))
+		batch := &pendingBatch{id: batchItems[0].BatchID, first: 1, last: 10, at: batchItems[0].BatchAt}
+		err = s.write(ctx, aggID, 9, batch, []Record{{Version: 10, Data: []byte("last data")}})
+		assert.Nil(ct, err)
+
+		result, _ := s.Load(ctx, aggID, 0, 0)
+		assert.Len(ct, result, 10)
+	})
+}
+
+func TestDynamoDBStoreReadAll(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithGlobalLog())
+	ctx := context.Background()
+
+	t.Run("test ReadAll without a global log (error)", func(ct *testing.T) {
+		_, err := GetDynamoDBStore(tableName, hashKey, rangeKey, db).ReadAll(ctx, 0, 0)
+		assert.NotNil(ct, err)
+	})
+
+	first, second := uuid.NewV4().String(), uuid.NewV4().String()
+	_ = s.Save(ctx, first, 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+	_ = s.Save(ctx, second, 0, Record{Version: 1, Data: []byte("second 1")})
+	_ = s.Save(ctx, first, 2, Record{Version: 3, Data: []byte("first 3")})
+
+	expected := []GlobalRecord{
+		{Record: Record{Version: 1, Data: []byte("first 1")}, AggregateID: first, Position: 1},
+		{Record: Record{Version: 2, Data: []byte("first 2")}, AggregateID: first, Position: 2},
+		{Record: Record{Version: 1, Data: []byte("second 1")}, AggregateID: second, Position: 3},
+		{Record: Record{Version: 3, Data: []byte("first 3")}, AggregateID: first, Position: 4},
+	}
+
+	t.Run("test ReadAll from the start", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected, result)
+	})
+
+	t.Run("test ReadAll from a position with a limit", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 2, 2)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected[1:3], result)
+	})
+
+	t.Run("test Load is unaffected by the global log", func(ct *testing.T) {
+		result, err := s.Load(ctx, first, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 3)
+	})
+
+	t.Run("test ReadAll across concurrent writers", func(ct *testing.T) {
+		const writers = 5
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.Save(ctx, uuid.NewV4().String(), 0, Record{Version: 1, Data: []byte("concurrent")})
+				assert.Nil(ct, err)
+			}()
+		}
+		wg.Wait()
+
+		result, err := s.ReadAll(ctx, 5, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, writers)
+		for i, record := range result {
+			assert.Equal(ct, int64(5+i), record.Position)
+		}
+	})
+
+	t.Run("test ReadAll a batch written over several transactions", func(ct *testing.T) {
+		end, _ := s.ReadAll(ctx, 0, 0)
+		next := end[len(end)-1].Position + 1
+
+		var records []Record
+		for i := 0; i < MaxTransactionItems; i++ {
+			records = append(records, Record{Version: i + 1, Data: []byte("batched")})
+		}
+		err := s.Save(ctx, uuid.NewV4().String(), 0, records...)
+		assert.Nil(ct, err)
+
+		result, err := s.ReadAll(ctx, next, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, len(records))
+	})
+
+	t.Run("test ReadAll stops at an uncommitted batch", func(ct *testing.T) {
+		end, _ := s.ReadAll(ctx, 0, 0)
+		next := end[len(end)-1].Position + 1
+
+		aggID := uuid.NewV4().String()
+		batch := &pendingBatch{id: uuid.NewV4().String(), first: 1, last: 10, at: time.Now().UnixNano()}
+		err := s.write(ctx, aggID, 0, batch, []Record{{Version: 1, Data: []byte("pending")}})
+		assert.Nil(ct, err)
+		_ = s.Save(ctx, uuid.NewV4().String(), 0, Record{Version: 1, Data: []byte("after the batch")})
+
+		result, err := s.ReadAll(ctx, next, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 0)
+
+		// Once the half-written batch is recovered, its position is skipped
+		err = s.Recover(ctx, aggID, 0)
+		assert.Nil(ct, err)
+		result, err = s.ReadAll(ctx, next, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 1)
+		assert.Equal(ct, next+1, result[0].Position)
+	})
+
+	t.Run("test ReadAll recovers a batch that timed out", func(ct *testing.T) {
+		end, _ := s.ReadAll(ctx, 0, 0)
+		next := end[len(end)-1].Position + 1
+
+		aggID := uuid.NewV4().String()
+		batch := &pendingBatch{id: uuid.NewV4().String(), first: 1, last: 10, at: time.Now().Add(-time.Hour).UnixNano()}
+		err := s.write(ctx, aggID, 0, batch, []Record{{Version: 1, Data: []byte("crashed")}})
+		assert.Nil(ct, err)
+		_ = s.Save(ctx, uuid.NewV4().String(), 0, Record{Version: 1, Data: []byte("after the batch")})
+
+		result, err := s.ReadAll(ctx, next, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 1)
+		assert.Equal(ct, next+1, result[0].Position)
+
+		_, err = s.Load(ctx, aggID, 0, 0)
+		assert.ErrorIs(ct, err, ErrAggregateNotFound)
+	})
+
+	t.Run("test the id of the global log is reserved (error)", func(ct *testing.T) {
+		err := s.Save(ctx, "$all", 0, Record{Version: 1, Data: []byte("clobber")})
+		assert.ErrorIs(ct, err, ErrReservedAggregateID)
+
+		err = s.SaveAll(ctx,
+			Append{AggregateID: uuid.NewV4().String(), Records: []Record{{Version: 1, Data: []byte("fine")}}},
+			Append{AggregateID: "$all", Records: []Record{{Version: 1, Data: []byte("clobber")}}},
+		)
+		assert.ErrorIs(ct, err, ErrReservedAggregateID)
+
+		_, err = s.Load(ctx, "$all", 0, 0)
+		assert.ErrorIs(ct, err, ErrReservedAggregateID)
+
+		result, err := s.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		for _, record := range result {
+			assert.NotEqual(ct, "clobber", string(record.Data))
+		}
+	})
+}
+
+func TestDynamoDBSnapshotStore(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	s := GetDynamoDBSnapshotStore(tableName, hashKey, rangeKey, db)
+	ctx := context.Background()
+
+	t.Run("test LoadSnapshot without a snapshot", func(ct *testing.T) {
+		snapshot, err := s.LoadSnapshot(ctx, uuid.NewV4().String())
+		assert.Nil(ct, err)
+		assert.Nil(ct, snapshot)
+	})
+
+	t.Run("test SaveSnapshot -> LoadSnapshot returns the newest", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		at := time.Now()
+		newest := Snapshot{AggregateID: aggID, Version: 10, Data: []byte("state at 10"), At: at}
+		assert.Nil(ct, s.SaveSnapshot(ctx, Snapshot{AggregateID: aggID, Version: 5, Data: []byte("state at 5"), At: at}))
+		assert.Nil(ct, s.SaveSnapshot(ctx, newest))
+		assert.Nil(ct, s.SaveSnapshot(ctx, Snapshot{AggregateID: aggID, Version: 7, Data: []byte("state at 7"), At: at}))
+
+		snapshot, err := s.LoadSnapshot(ctx, aggID)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 10, snapshot.Version)
+		assert.Equal(ct, newest.Data, snapshot.Data)
+		assert.True(ct, at.Equal(snapshot.At))
+
+		// Older snapshots are overwritten rather than kept
+		out, err := db.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			ConsistentRead:         aws.Bool(true),
+			KeyConditionExpression: aws.String("#key = :key"),
+			ExpressionAttributeNames: map[string]string{
+				"#key": hashKey,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":key": &types.AttributeValueMemberS{Value: aggID},
+			},
+		})
+		assert.Nil(ct, err)
+		assert.Len(ct, out.Items, 1)
+	})
+}
+
+func TestDynamoDBDeadLetterStore(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	testDeadLetterStore(t, GetDynamoDBDeadLetterStore(tableName, hashKey, rangeKey, db))
+}
+
+// recordingPublisher remembers what it published and fails for the aggregates in failFor
+type recordingPublisher struct {
+	mux       sync.Mutex
+	published map[string][]int
+	failFor   map[string]bool
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, aggregateID string, record Record) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.failFor[aggregateID] {
+		return errors.New("broker unavailable")
+	}
+	p.published[aggregateID] = append(p.published[aggregateID], record.Version)
+	return nil
+}
+
+func TestDynamoDBStoreOutbox(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+	outboxTableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+	testutils.CreateTestTable(outboxTableName, hashKey, db)
+	defer testutils.DestroyTestTable(outboxTableName, db)
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithOutbox(outboxTableName))
+	ctx := context.Background()
+
+	t.Run("test NewOutboxRelay without an outbox (error)", func(ct *testing.T) {
+		_, err := NewOutboxRelay(GetDynamoDBStore(tableName, hashKey, rangeKey, db), &recordingPublisher{})
+		assert.NotNil(ct, err)
+	})
+
+	t.Run("test Drain publishes every record in version order", func(ct *testing.T) {
+		first, second := uuid.NewV4().String(), uuid.NewV4().String()
+		_ = s.Save(ctx, first, 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+		_ = s.Save(ctx, second, 0, Record{Version: 1, Data: []byte("second 1")})
+		_ = s.Save(ctx, first, 2, Record{Version: 3, Data: []byte("first 3")})
+
+		// A rejected write leaves nothing in the outbox
+		err := s.Save(ctx, second, 0, Record{Version: 1, Data: []byte("competing")})
+		assert.NotNil(ct, err)
+
+		publisher := &recordingPublisher{published: map[string][]int{}}
+		relay, err := NewOutboxRelay(s, publisher)
+		assert.Nil(ct, err)
+
+		count, err := relay.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 4, count)
+		assert.Equal(ct, []int{1, 2, 3}, publisher.published[first])
+		assert.Equal(ct, []int{1}, publisher.published[second])
+
+		// Published records are removed from the outbox
+		count, err = relay.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 0, count)
+	})
+
+	t.Run("test Drain keeps records that failed to publish", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		_ = s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first")}, Record{Version: 2, Data: []byte("second")})
+
+		publisher := &recordingPublisher{published: map[string][]int{}, failFor: map[string]bool{aggID: true}}
+		relay, _ := NewOutboxRelay(s, publisher)
+		count, err := relay.Drain(ctx)
+		assert.NotNil(ct, err)
+		assert.Equal(ct, 0, count)
+
+		publisher.failFor = nil
+		count, err = relay.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 2, count)
+		assert.Equal(ct, []int{1, 2}, publisher.published[aggID])
+	})
+
+	t.Run("test Drain holds back an uncommitted batch", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		batch := &pendingBatch{id: uuid.NewV4().String(), first: 1, last: 10, at: time.Now().UnixNano()}
+		err := s.write(ctx, aggID, 0, batch, []Record{{Version: 1, Data: []byte("pending")}})
+		assert.Nil(ct, err)
+
+		publisher := &recordingPublisher{published: map[string][]int{}}
+		relay, _ := NewOutboxRelay(s, publisher)
+		count, err := relay.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 0, count)
+
+		// Recovering the batch removes its copies from the outbox as well
+		err = s.Recover(ctx, aggID, 0)
+		assert.Nil(ct, err)
+		out, err := db.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(outboxTableName), ConsistentRead: aws.Bool(true)})
+		assert.Nil(ct, err)
+		assert.Len(ct, out.Items, 0)
+	})
+}
+
+func TestDynamoDBStoreSaveAll(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithGlobalLog())
+	ctx := context.Background()
+
+	from, to := uuid.NewV4().String(), uuid.NewV4().String()
+	_ = s.Save(ctx, from, 0, Record{Version: 1, Data: []byte("opened")})
+
+	t.Run("test SaveAll to several streams", func(ct *testing.T) {
+		err := s.SaveAll(ctx,
+			Append{AggregateID: from, ExpectedVersion: 1, Records: []Record{{Version: 2, Data: []byte("debited")}}},
+			Append{AggregateID: to, ExpectedVersion: 0, Records: []Record{{Version: 1, Data: []byte("credited")}}},
+		)
+		assert.Nil(ct, err)
+
+		fromHistory, _ := s.Load(ctx, from, 0, 0)
+		assert.Len(ct, fromHistory, 2)
+		toHistory, _ := s.Load(ctx, to, 0, 0)
+		assert.Len(ct, toHistory, 1)
+
+		all, err := s.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, all, 3)
+		assert.Equal(ct, from, all[1].AggregateID)
+		assert.Equal(ct, to, all[2].AggregateID)
+	})
+
+	t.Run("test SaveAll with one stale stream writes nothing (error)", func(ct *testing.T) {
+		err := s.SaveAll(ctx,
+			Append{AggregateID: from, ExpectedVersion: 2, Records: []Record{{Version: 3, Data: []byte("debited")}}},
+			Append{AggregateID: to, ExpectedVersion: 0, Records: []Record{{Version: 1, Data: []byte("credited")}}},
+		)
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, to, conflict.AggregateID)
+		assert.Equal(ct, 1, conflict.ActualVersion)
+
+		fromHistory, _ := s.Load(ctx, from, 0, 0)
+		assert.Len(ct, fromHistory, 2)
+	})
+
+	t.Run("test SaveAll more records than fit in one transaction (error)", func(ct *testing.T) {
+		var records []Record
+		for i := 0; i < MaxTransactionItems; i++ {
+			records = append(records, Record{Version: i + 1, Data: []byte("too many")})
+		}
+		err := s.SaveAll(ctx,
+			Append{AggregateID: uuid.NewV4().String(), Records: records},
+			Append{AggregateID: uuid.NewV4().String(), Records: records[:1]},
+		)
+		assert.NotNil(ct, err)
+	})
+}
+
+func TestDynamoDBStoreTracing(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	recorder := tracetest.NewSpanRecorder()
+	s := GetTracingStore(GetDynamoDBStore(tableName, hashKey, rangeKey, db), sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	ctx := context.Background()
+
+	t.Run("test spans report the consumed capacity", func(ct *testing.T) {
+		err := s.Save(ctx, "traced", 0, Record{Version: 1, Data: []byte("first"), EventType: "TodoCreated"})
+		assert.Nil(ct, err)
+		_, err = s.Load(ctx, "traced", 0, 0)
+		assert.Nil(ct, err)
+
+		spans := recorder.Ended()
+		assert.Len(ct, spans, 2)
+		for _, span := range spans {
+			attrs := spanAttributes(span)
+			assert.Equal(ct, "dynamodb", attrs[BackendKey].AsString())
+			assert.Greater(ct, attrs[ConsumedCapacityKey].AsFloat64(), 0.0)
+		}
+	})
+}
+
+func TestDynamoDBStoreMetrics(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+
+	recorder := &countingRecorder{}
+	s := GetMetricsStore(GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithMetrics(recorder)), recorder)
+	ctx := context.Background()
+
+	t.Run("test a stale write counts a failed condition", func(ct *testing.T) {
+		err := s.Save(ctx, "counted", 0, Record{Version: 1, Data: []byte("first"), EventType: "TodoCreated"})
+		assert.Nil(ct, err)
+		assert.Equal(ct, 0, recorder.checksFailed)
+
+		err = s.Save(ctx, "counted", 0, Record{Version: 1, Data: []byte("competing"), EventType: "TodoCreated"})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 1, recorder.checksFailed)
+		assert.Equal(ct, map[string]int{"dynamodb/TodoCreated": 1}, recorder.appended)
+	})
+}
+
+// recordingHandler remembers the versions it handled by aggregate and fails for the aggregates in failFor
+type recordingHandler struct {
+	handled map[string][]int
+	failFor map[string]bool
+}
+
+func (h *recordingHandler) HandleRecord(_ context.Context, aggregateID string, record Record) error {
+	if h.failFor[aggregateID] {
+		return errors.New("handler unavailable")
+	}
+	h.handled[aggregateID] = append(h.handled[aggregateID], record.Version)
+	return nil
+}
+
+func TestDynamoDBStreamSubscriber(t *testing.T) {
+	db := dynamodb.NewFromConfig(conf.GetAWSCfg())
+	streams := dynamodbstreams.NewFromConfig(conf.GetAWSCfg())
+	tableName := "todo_es_table_test_" + uuid.NewV4().String()
+	checkpointTableName := "todo_es_table_test_" + uuid.NewV4().String()
+
+	testutils.CreateTestTable(tableName, hashKey, db)
+	defer testutils.DestroyTestTable(tableName, db)
+	testutils.CreateTestTable(checkpointTableName, hashKey, db)
+	defer testutils.DestroyTestTable(checkpointTableName, db)
+
+	s := GetDynamoDBStore(tableName, hashKey, rangeKey, db, WithGlobalLog())
+	checkpoints := GetDynamoDBCheckpointStore(checkpointTableName, hashKey, rangeKey, db)
+	ctx := context.Background()
+
+	t.Run("test Drain without a stream (error)", func(ct *testing.T) {
+		subscriber := NewStreamSubscriber(s, streams, checkpoints, "no-stream", &recordingHandler{})
+		_, err := subscriber.Drain(ctx)
+		assert.NotNil(ct, err)
+	})
+
+	testutils.EnableTestTableStream(tableName, db)
+
+	first, second := uuid.NewV4().String(), uuid.NewV4().String()
+	_ = s.Save(ctx, first, 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+	_ = s.Save(ctx, second, 0, Record{Version: 1, Data: []byte("second 1")})
+	_ = s.Save(ctx, first, 2, Record{Version: 3, Data: []byte("first 3")})
+
+	t.Run("test Drain hands every record over in version order", func(ct *testing.T) {
+		var records []Record
+		subscriber := NewStreamSubscriber(s, streams, checkpoints, "decoder",
+			RecordHandlerFunc(func(_ context.Context, aggregateID string, record Record) error {
+				if aggregateID == first {
+					records = append(records, record)
+				}
+				return nil
+			}),
+		)
+		count, err := subscriber.Drain(ctx)
+		assert.Nil(ct, err)
+		// The entries of the global log are not records
+		assert.Equal(ct, 4, count)
+		assert.Len(ct, records, 3)
+		assert.Equal(ct, []byte("first 3"), records[2].Data)
+	})
+
+	t.Run("test a subscriber resumes after its checkpoint", func(ct *testing.T) {
+		handler := &recordingHandler{handled: map[string][]int{}}
+		count, err := NewStreamSubscriber(s, streams, checkpoints, "resumer", handler).Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 4, count)
+		assert.Equal(ct, []int{1, 2, 3}, handler.handled[first])
+		assert.Equal(ct, []int{1}, handler.handled[second])
+
+		_ = s.Save(ctx, second, 1, Record{Version: 2, Data: []byte("second 2")})
+		handler = &recordingHandler{handled: map[string][]int{}}
+		count, err = NewStreamSubscriber(s, streams, checkpoints, "resumer", handler).Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 1, count)
+		assert.Equal(ct, []int{2}, handler.handled[second])
+	})
+
+	t.Run("test Drain hands a failed record over again", func(ct *testing.T) {
+		handler := &recordingHandler{handled: map[string][]int{}, failFor: map[string]bool{second: true}}
+		subscriber := NewStreamSubscriber(s, streams, GetLocalCheckpointStore(), "retrier", handler)
+		_, err := subscriber.Drain(ctx)
+		assert.NotNil(ct, err)
+		assert.Len(ct, handler.handled[second], 0)
+
+		handler.failFor = nil
+		_, err = subscriber.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, []int{1, 2}, handler.handled[second])
+	})
+
+	t.Run("test Drain holds back an uncommitted batch", func(ct *testing.T) {
+		handler := &recordingHandler{handled: map[string][]int{}}
+		subscriber := NewStreamSubscriber(s, streams, GetLocalCheckpointStore(), "batches", handler)
+		_, err := subscriber.Drain(ctx)
+		assert.Nil(ct, err)
+
+		aggID := uuid.NewV4().String()
+		batch := &pendingBatch{id: uuid.NewV4().String(), first: 1, last: 2, at: time.Now().UnixNano()}
+		err = s.write(ctx, aggID, 0, batch, []Record{{Version: 1, Data: []byte("pending")}})
+		assert.Nil(ct, err)
+		count, err := subscriber.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 0, count)
+
+		err = s.write(ctx, aggID, 1, batch, []Record{{Version: 2, Data: []byte("committed")}})
+		assert.Nil(ct, err)
+		count, err = subscriber.Drain(ctx)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 2, count)
+		assert.Equal(ct, []int{1, 2}, handler.handled[aggID])
+	})
 }
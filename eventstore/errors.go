@@ -0,0 +1,42 @@
+package eventstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAggregateNotFound is returned by Load when the store holds no events of the aggregate.
+// Stores wrap it with the aggregate id, so test for it with errors.Is.
+var ErrAggregateNotFound = errors.New("aggregate not found")
+
+// notFound returns ErrAggregateNotFound for an aggregate
+func notFound(aggregateID string) error {
+	return fmt.Errorf("%w: %s", ErrAggregateNotFound, aggregateID)
+}
+
+// ErrReservedAggregateID is returned by the DynamoDB store for an aggregate id it keeps for itself, like the
+// id its global log lives under
+var ErrReservedAggregateID = errors.New("reserved aggregate id")
+
+// ErrDeadLetterNotFound is returned by LoadDeadLetter when there is no dead letter with the id
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// ErrConcurrencyConflict is returned by Save when the stream is not at the version the caller expected
+type ErrConcurrencyConflict struct {
+	// AggregateID contains the id of the stream that was written to
+	AggregateID string
+
+	// ExpectedVersion contains the version the caller built its records upon
+	ExpectedVersion int
+
+	// ActualVersion contains the version the stream was found at
+	ActualVersion int
+}
+
+// Error implements the error interface
+func (e *ErrConcurrencyConflict) Error() string {
+	return fmt.Sprintf(
+		"concurrency conflict on aggregate %s: expected version %d, actual version %d",
+		e.AggregateID, e.ExpectedVersion, e.ActualVersion,
+	)
+}
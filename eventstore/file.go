@@ -0,0 +1,656 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSegmentBytes is the size past which the file store starts a new segment
+const DefaultMaxSegmentBytes = 64 << 20
+
+// errFileStoreClosed is returned by every call on a file store after Close
+var errFileStoreClosed = errors.New("the file store is closed")
+
+// SyncPolicy decides when the file store flushes appended records to stable storage
+type SyncPolicy struct {
+	always   bool
+	interval time.Duration
+}
+
+var (
+	// SyncAlways flushes before every Save returns, so a saved record survives a power loss. It is the default.
+	SyncAlways = SyncPolicy{always: true}
+	// SyncNever leaves flushing to the operating system; a crash of the machine may lose recent records
+	SyncNever = SyncPolicy{}
+)
+
+// SyncEvery flushes in the background; a crash of the machine may lose the records of the last interval
+func SyncEvery(interval time.Duration) SyncPolicy {
+	return SyncPolicy{interval: interval}
+}
+
+// FileOption configures a FileStore
+type FileOption func(*FileStore)
+
+// WithSyncPolicy sets when appended records are flushed to disk
+func WithSyncPolicy(policy SyncPolicy) FileOption {
+	return func(f *FileStore) {
+		f.policy = policy
+	}
+}
+
+// WithMaxSegmentBytes sets the size past which a new segment is started
+func WithMaxSegmentBytes(size int64) FileOption {
+	return func(f *FileStore) {
+		f.maxSegmentBytes = size
+	}
+}
+
+// fileEntry locates the data and metadata of a record in a segment
+type fileEntry struct {
+	aggregateID string
+	version     int
+	position    int64
+	segment     *segment
+	kind        byte
+	offset      int64
+	length      int
+	metaLength  int
+	deleted     bool
+}
+
+// FileStore is an event store implementation on an append-only log in a local directory, for deployments
+// without a database. The log is split in segments; only the last one is written to, the others are sealed.
+// An index of every record's place on disk is kept in memory and rebuilt from the segments on open.
+// Only one process may open a directory at a time.
+type FileStore struct {
+	dir             string
+	policy          SyncPolicy
+	maxSegmentBytes int64
+
+	mux          sync.RWMutex
+	segments     []*segment
+	byID         map[string][]*fileEntry
+	all          []*fileEntry
+	nextPosition int64
+	dirty        bool
+	failed       error
+	closed       bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// GetFileStore opens the file store in dir, creating the directory when needed.
+// Frames torn by a crash at the end of the log are truncated; damage anywhere else is reported as an error.
+func GetFileStore(dir string, opts ...FileOption) (*FileStore, error) {
+	f := &FileStore{
+		dir:             dir,
+		policy:          SyncAlways,
+		maxSegmentBytes: DefaultMaxSegmentBytes,
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	if err := f.load(); err != nil {
+		f.closeFiles()
+		return nil, err
+	}
+
+	if f.policy.interval > 0 {
+		f.wg.Add(1)
+		go f.syncEvery(f.policy.interval)
+	}
+	return f, nil
+}
+
+// load opens the segments and rebuilds the index
+func (f *FileStore) load() error {
+	if err := finishCompaction(f.dir); err != nil {
+		return err
+	}
+	segments, err := listSegments(f.dir)
+	if err != nil {
+		return err
+	}
+
+	f.segments = nil
+	f.byID = map[string][]*fileEntry{}
+	f.all = nil
+	f.nextPosition = 1
+	for i, seg := range segments {
+		size, scanErr := scanSegment(seg, func(offset, _ int64, fr frame) error {
+			f.apply(seg, offset, fr)
+			return nil
+		})
+		switch {
+		case errors.Is(scanErr, errTornFrame) && i == len(segments)-1:
+			// The process stopped in the middle of an append, which therefore never returned successfully
+			if err = os.Truncate(seg.path, size); err != nil {
+				return err
+			}
+		case scanErr != nil:
+			return fmt.Errorf("segment %s is damaged at offset %d: %w", seg.path, size, scanErr)
+		}
+		seg.size = size
+	}
+	f.dropDeleted()
+
+	if len(segments) == 0 {
+		segments = append(segments, &segment{base: f.nextPosition, path: filepath.Join(f.dir, segmentName(f.nextPosition))})
+	}
+	for i, seg := range segments {
+		flag := os.O_RDONLY
+		if i == len(segments)-1 {
+			flag = os.O_RDWR | os.O_CREATE
+		}
+		if seg.file, err = os.OpenFile(seg.path, flag, 0o600); err != nil {
+			return err
+		}
+		f.segments = append(f.segments, seg)
+	}
+	return syncDir(f.dir)
+}
+
+// apply adds what a frame read from a segment does to the index
+func (f *FileStore) apply(seg *segment, offset int64, fr frame) {
+	if fr.kind == frameKindDeletion {
+		f.forget(fr.aggregateID)
+		if fr.position > f.nextPosition {
+			f.nextPosition = fr.position
+		}
+		return
+	}
+
+	for i, record := range fr.records {
+		entry := &fileEntry{
+			aggregateID: fr.aggregateID,
+			version:     record.version,
+			position:    fr.position + int64(i),
+			segment:     seg,
+			kind:        fr.kind,
+			offset:      offset + record.dataOffset,
+			length:      record.dataLen,
+			metaLength:  record.metaLen,
+		}
+		f.byID[fr.aggregateID] = append(f.byID[fr.aggregateID], entry)
+		f.all = append(f.all, entry)
+	}
+	f.nextPosition = fr.position + int64(len(fr.records))
+}
+
+// forget removes a stream from the index; call dropDeleted to remove its records from the global order as well
+func (f *FileStore) forget(aggregateID string) {
+	for _, entry := range f.byID[aggregateID] {
+		entry.deleted = true
+	}
+	delete(f.byID, aggregateID)
+}
+
+func (f *FileStore) dropDeleted() {
+	kept := f.all[:0]
+	for _, entry := range f.all {
+		if !entry.deleted {
+			kept = append(kept, entry)
+		}
+	}
+	f.all = kept
+}
+
+// usable returns why the store can no longer be used, if it cannot
+func (f *FileStore) usable() error {
+	if f.closed {
+		return errFileStoreClosed
+	}
+	return f.failed
+}
+
+func (f *FileStore) active() *segment {
+	return f.segments[len(f.segments)-1]
+}
+
+// Save implements the EventStore interface and appends the records as a single frame
+func (f *FileStore) Save(_ context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := checkVersions(expectedVersion, records); err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.usable(); err != nil {
+		return err
+	}
+
+	current := f.byID[aggregateID]
+	actualVersion := 0
+	if len(current) > 0 {
+		actualVersion = current[len(current)-1].version
+	}
+	if actualVersion != expectedVersion {
+		return &ErrConcurrencyConflict{
+			AggregateID:     aggregateID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   actualVersion,
+		}
+	}
+
+	data := encodeFrame(frameKindRecordsMeta, f.nextPosition, aggregateID, records)
+	seg, offset, err := f.append(data)
+	if err != nil {
+		return err
+	}
+
+	fr, err := decodePayload(data[frameHeaderSize:])
+	if err != nil {
+		return err
+	}
+	f.apply(seg, offset, fr)
+	return nil
+}
+
+// Delete removes a stream by appending a tombstone; Compact reclaims the space its records take
+func (f *FileStore) Delete(_ context.Context, aggregateID string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.usable(); err != nil {
+		return err
+	}
+	if _, ok := f.byID[aggregateID]; !ok {
+		return nil
+	}
+
+	// A tombstone takes no position of its own; it removes the stream's records positioned before it
+	if _, _, err := f.append(encodeFrame(frameKindDeletion, f.nextPosition, aggregateID, nil)); err != nil {
+		return err
+	}
+	f.forget(aggregateID)
+	f.dropDeleted()
+	return nil
+}
+
+// append writes a frame at the end of the log, starting a new segment when the active one is full
+func (f *FileStore) append(data []byte) (*segment, int64, error) {
+	seg := f.active()
+	// A segment that starts with a tombstone may be named after the next position already
+	if seg.size > 0 && seg.size+int64(len(data)) > f.maxSegmentBytes && seg.base < f.nextPosition {
+		var err error
+		if seg, err = f.rotate(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	offset := seg.size
+	if _, err := seg.file.WriteAt(data, offset); err != nil {
+		// Cut off whatever part of the frame made it to the file, so the next frame does not land after it
+		if truncateErr := seg.file.Truncate(offset); truncateErr != nil {
+			f.failed = fmt.Errorf("unable to roll back a failed append to %s: %v", seg.path, truncateErr)
+		}
+		return nil, 0, err
+	}
+	seg.size += int64(len(data))
+
+	switch {
+	case f.policy.always:
+		if err := seg.file.Sync(); err != nil {
+			// The kernel may have dropped the pages it could not write, so nothing written since the last
+			// successful sync can be trusted any longer
+			f.failed = err
+			return nil, 0, err
+		}
+	case f.policy.interval > 0:
+		f.dirty = true
+	}
+	return seg, offset, nil
+}
+
+// rotate seals the active segment and starts a new one named after the next position
+func (f *FileStore) rotate() (*segment, error) {
+	if f.policy != SyncNever {
+		if err := f.active().file.Sync(); err != nil {
+			f.failed = err
+			return nil, err
+		}
+		f.dirty = false
+	}
+
+	path := filepath.Join(f.dir, segmentName(f.nextPosition))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{base: f.nextPosition, path: path, file: file}
+	f.segments = append(f.segments, seg)
+	return seg, syncDir(f.dir)
+}
+
+func (f *FileStore) syncEvery(interval time.Duration) {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.mux.Lock()
+			if f.dirty && f.usable() == nil {
+				if err := f.active().file.Sync(); err != nil {
+					f.failed = err
+				}
+				f.dirty = false
+			}
+			f.mux.Unlock()
+		}
+	}
+}
+
+// Load implements the EventStore interface and reads the events of a specific aggregateID in version order
+func (f *FileStore) Load(_ context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if f.closed {
+		return nil, errFileStoreClosed
+	}
+
+	entries, ok := f.byID[aggregateID]
+	if !ok {
+		return nil, notFound(aggregateID)
+	}
+
+	history := make(History, 0, len(entries))
+	for _, entry := range entries {
+		if v := entry.version; v >= fromVersion && (toVersion == 0 || v <= toVersion) {
+			record, err := entry.read()
+			if err != nil {
+				return nil, err
+			}
+			history = append(history, record)
+		}
+	}
+	return history, nil
+}
+
+func (e *fileEntry) read() (Record, error) {
+	data := make([]byte, e.length+e.metaLength)
+	if _, err := e.segment.file.ReadAt(data, e.offset); err != nil {
+		return Record{}, fmt.Errorf("unable to read version %d of %s: %w", e.version, e.aggregateID, err)
+	}
+	return decodeRecord(e.kind, e.version, data, e.length)
+}
+
+// ReadAll implements the GlobalReader interface; the records of deleted streams are skipped
+func (f *FileStore) ReadAll(_ context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if f.closed {
+		return nil, errFileStoreClosed
+	}
+
+	start := sort.Search(len(f.all), func(i int) bool {
+		return f.all[i].position >= fromPosition
+	})
+	rest := f.all[start:]
+	if limit > 0 && limit < len(rest) {
+		rest = rest[:limit]
+	}
+
+	result := make([]GlobalRecord, 0, len(rest))
+	for _, entry := range rest {
+		record, err := entry.read()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, GlobalRecord{
+			Record:      record,
+			AggregateID: entry.aggregateID,
+			Position:    entry.position,
+		})
+	}
+	return result, nil
+}
+
+// compactionPlan is written to the compaction marker once the compacted segments are on disk.
+// From then on the compaction is committed: an interrupted one is finished the next time the store is opened.
+type compactionPlan struct {
+	Sealed    []string `json:"sealed"`
+	Compacted []string `json:"compacted"`
+}
+
+// Compact rewrites the sealed segments without the records of deleted streams and without tombstones.
+// Saves and loads wait while it runs.
+func (f *FileStore) Compact(ctx context.Context) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err := f.usable(); err != nil {
+		return err
+	}
+	sealed := f.segments[:len(f.segments)-1]
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	live := map[int64]bool{}
+	for _, entry := range f.all {
+		if entry.segment != f.active() {
+			live[entry.position] = true
+		}
+	}
+
+	plan, err := f.writeCompacted(ctx, sealed, live)
+	if err != nil {
+		for _, name := range plan.Compacted {
+			_ = os.Remove(filepath.Join(f.dir, name+tempSuffix))
+		}
+		return err
+	}
+	if err = writeCompactionPlan(f.dir, plan); err != nil {
+		return err
+	}
+
+	f.closeFiles()
+	if err = f.load(); err != nil {
+		f.failed = fmt.Errorf("unable to reopen the store after compacting it, reopen it to finish: %w", err)
+		return f.failed
+	}
+	return nil
+}
+
+// writeCompacted copies the live frames of the sealed segments to temporary files, which it flushes
+func (f *FileStore) writeCompacted(ctx context.Context, sealed []*segment, live map[int64]bool) (compactionPlan, error) {
+	var plan compactionPlan
+	var out *os.File
+	var outSize int64
+	finish := func() error {
+		if out == nil {
+			return nil
+		}
+		err := out.Sync()
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		out = nil
+		return err
+	}
+
+	for _, seg := range sealed {
+		plan.Sealed = append(plan.Sealed, filepath.Base(seg.path))
+		if err := ctx.Err(); err != nil {
+			_ = finish()
+			return plan, err
+		}
+
+		_, err := scanSegment(seg, func(offset, size int64, fr frame) error {
+			if fr.kind == frameKindDeletion || !live[fr.position] {
+				return nil
+			}
+			if out != nil && outSize+size > f.maxSegmentBytes {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+			if out == nil {
+				name := segmentName(fr.position)
+				plan.Compacted = append(plan.Compacted, name)
+				file, err := os.OpenFile(filepath.Join(f.dir, name+tempSuffix), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+				if err != nil {
+					return err
+				}
+				out, outSize = file, 0
+			}
+
+			data := make([]byte, size)
+			if _, err := seg.file.ReadAt(data, offset); err != nil {
+				return err
+			}
+			if _, err := out.Write(data); err != nil {
+				return err
+			}
+			outSize += size
+			return nil
+		})
+		if err != nil {
+			_ = finish()
+			return plan, err
+		}
+	}
+	return plan, finish()
+}
+
+// writeCompactionPlan atomically writes the compaction marker, which commits the compaction
+func writeCompactionPlan(dir string, plan compactionPlan) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, compactionMarker)
+	file, err := os.OpenFile(path+tempSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(path+tempSuffix, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// finishCompaction swaps in the segments of a committed compaction. Renames replace sealed segments of the
+// same name, and only sealed segments that were not replaced are removed, so it is safe to repeat.
+func finishCompaction(dir string) error {
+	path := filepath.Join(dir, compactionMarker)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var plan compactionPlan
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return fmt.Errorf("unable to read the compaction marker: %w", err)
+	}
+
+	compacted := map[string]bool{}
+	for _, name := range plan.Compacted {
+		compacted[name] = true
+		err = os.Rename(filepath.Join(dir, name+tempSuffix), filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for _, name := range plan.Sealed {
+		if compacted[name] {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory, so files created, renamed or removed in it stay that way after a crash
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be opened for syncing on Windows, where metadata changes are journaled instead
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *FileStore) closeFiles() {
+	for _, seg := range f.segments {
+		if seg.file != nil {
+			_ = seg.file.Close()
+		}
+	}
+	f.segments = nil
+}
+
+// Close flushes the log and releases its files
+func (f *FileStore) Close() error {
+	f.mux.Lock()
+	if f.closed {
+		f.mux.Unlock()
+		return nil
+	}
+	f.closed = true
+	var err error
+	if len(f.segments) > 0 && f.failed == nil {
+		err = f.active().file.Sync()
+	}
+	f.closeFiles()
+	f.mux.Unlock()
+
+	close(f.done)
+	f.wg.Wait()
+	return err
+}
@@ -0,0 +1,317 @@
+package eventstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A segment is a file of frames. Every Save appends one frame, so a batch is either entirely on disk or, when
+// the process died mid-write, ends in a torn frame that recovery truncates.
+//
+//	frame:    length uint32 | crc32c(payload) uint32 | payload
+//	payload:  kind byte | uvarint position | string id | uvarint count | count * record
+//	record:   uvarint version | uvarint len(data) | data | metadata
+//	metadata: string type | string id | uvarint occurred at | string correlation | string causation |
+//	          uvarint count | count * (string key | string value)
+//	string:   uvarint len | bytes
+//
+// position is the global position of the frame's first record; a tombstone frame has no records and
+// removes every earlier record of its aggregate. Frames written before records had metadata are of kind
+// frameKindRecords, and their records end after data. Occurred at is in Unix nanoseconds plus one, so 0
+// stands for the zero time.
+const (
+	frameHeaderSize      = 8
+	frameKindRecords     = byte(1)
+	frameKindDeletion    = byte(2)
+	frameKindRecordsMeta = byte(3)
+	segmentSuffix        = ".seg"
+	tempSuffix           = ".tmp"
+	compactionMarker     = "COMPACTION"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornFrame marks a frame that was only partially written
+var errTornFrame = errors.New("torn frame")
+
+// errCorruptFrame marks a frame that fails its checksum or cannot be decoded, yet is followed by more of the
+// segment, so it cannot be the last append of a crashed process
+var errCorruptFrame = errors.New("corrupt frame")
+
+// segment is one file of the log, named after the position of its first frame
+type segment struct {
+	base int64
+	path string
+	file *os.File
+	size int64
+}
+
+// frame is a decoded frame; record data offsets are relative to the start of the frame
+type frame struct {
+	kind        byte
+	position    int64
+	aggregateID string
+	records     []frameRecord
+}
+
+type frameRecord struct {
+	version    int
+	dataOffset int64
+	dataLen    int
+	metaLen    int
+}
+
+func segmentName(base int64) string {
+	return fmt.Sprintf("%020d%s", base, segmentSuffix)
+}
+
+// listSegments returns the segment files of a directory in log order.
+// Temporary files are left over from a compaction that never committed and are removed.
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, tempSuffix):
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(name, segmentSuffix):
+			base, parseErr := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+			if parseErr != nil {
+				return nil, fmt.Errorf("unexpected segment file %s", name)
+			}
+			segments = append(segments, &segment{base: base, path: filepath.Join(dir, name)})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].base < segments[j].base
+	})
+	return segments, nil
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	buf.Write(scratch[:n])
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func putMetadata(buf *bytes.Buffer, record Record) {
+	putString(buf, record.EventType)
+	putString(buf, record.EventID)
+	var occurredAt uint64
+	if !record.OccurredAt.IsZero() {
+		occurredAt = uint64(record.OccurredAt.UnixNano()) + 1
+	}
+	putUvarint(buf, occurredAt)
+	putString(buf, record.CorrelationID)
+	putString(buf, record.CausationID)
+
+	keys := make([]string, 0, len(record.Headers))
+	for k := range record.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	putUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		putString(buf, k)
+		putString(buf, record.Headers[k])
+	}
+}
+
+// encodeFrame builds the bytes of a frame, header included
+func encodeFrame(kind byte, position int64, aggregateID string, records []Record) []byte {
+	var payload bytes.Buffer
+	payload.WriteByte(kind)
+	putUvarint(&payload, uint64(position))
+	putString(&payload, aggregateID)
+	putUvarint(&payload, uint64(len(records)))
+	for _, record := range records {
+		putUvarint(&payload, uint64(record.Version))
+		putUvarint(&payload, uint64(len(record.Data)))
+		payload.Write(record.Data)
+		if kind == frameKindRecordsMeta {
+			putMetadata(&payload, record)
+		}
+	}
+
+	out := make([]byte, frameHeaderSize, frameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(out[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(out[4:8], crc32.Checksum(payload.Bytes(), crcTable))
+	return append(out, payload.Bytes()...)
+}
+
+// payloadDecoder reads the fields of a payload, remembering the first error it ran into
+type payloadDecoder struct {
+	payload []byte
+	offset  int
+	err     error
+}
+
+func (d *payloadDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.payload[d.offset:])
+	if n <= 0 {
+		d.err = errTornFrame
+		return 0
+	}
+	d.offset += n
+	return v
+}
+
+func (d *payloadDecoder) next(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.payload)-d.offset) {
+		d.err = errTornFrame
+		return nil
+	}
+	b := d.payload[d.offset : d.offset+int(n)]
+	d.offset += int(n)
+	return b
+}
+
+func (d *payloadDecoder) string() string {
+	return string(d.next(d.uvarint()))
+}
+
+// metadata reads the metadata of a record into it
+func (d *payloadDecoder) metadata(record *Record) {
+	record.EventType = d.string()
+	record.EventID = d.string()
+	if occurredAt := d.uvarint(); occurredAt > 0 {
+		record.OccurredAt = time.Unix(0, int64(occurredAt-1))
+	}
+	record.CorrelationID = d.string()
+	record.CausationID = d.string()
+	if count := d.uvarint(); count > 0 && d.err == nil {
+		record.Headers = map[string]string{}
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			k := d.string()
+			record.Headers[k] = d.string()
+		}
+	}
+}
+
+// decodePayload parses a frame's payload, which has already passed its checksum
+func decodePayload(payload []byte) (frame, error) {
+	d := &payloadDecoder{payload: payload}
+	var f frame
+
+	if kind := d.next(1); kind != nil {
+		f.kind = kind[0]
+	}
+	f.position = int64(d.uvarint())
+	f.aggregateID = d.string()
+
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		version := int(d.uvarint())
+		dataLen := d.uvarint()
+		offset := d.offset
+		d.next(dataLen)
+		metaOffset := d.offset
+		if f.kind == frameKindRecordsMeta {
+			d.metadata(&Record{})
+		}
+		f.records = append(f.records, frameRecord{
+			version:    version,
+			dataOffset: int64(frameHeaderSize + offset),
+			dataLen:    int(dataLen),
+			metaLen:    d.offset - metaOffset,
+		})
+	}
+	return f, d.err
+}
+
+// decodeRecord parses the data and metadata of a record read from a frame of the given kind
+func decodeRecord(kind byte, version int, data []byte, dataLen int) (Record, error) {
+	record := Record{Version: version, Data: data[:dataLen:dataLen]}
+	if kind == frameKindRecordsMeta {
+		d := &payloadDecoder{payload: data[dataLen:]}
+		d.metadata(&record)
+		if d.err != nil {
+			return Record{}, d.err
+		}
+	}
+	return record, nil
+}
+
+// scanSegment calls fn with every complete frame of a segment, its offset in the file and its size.
+// It returns the size of the intact part of the segment along with errTornFrame when the tail is torn: the last
+// frame is incomplete, or runs to the end of the file without passing its checksum. A bad frame followed by
+// more frames is errCorruptFrame.
+func scanSegment(s *segment, fn func(offset, size int64, f frame) error) (int64, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			return offset, errTornFrame
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		if offset+frameHeaderSize+int64(length) > info.Size() {
+			return offset, errTornFrame
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return offset, errTornFrame
+		}
+		size := frameHeaderSize + int64(length)
+		damaged := errCorruptFrame
+		if offset+size == info.Size() {
+			damaged = errTornFrame
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, damaged
+		}
+
+		f, decodeErr := decodePayload(payload)
+		if decodeErr != nil {
+			return offset, damaged
+		}
+		if err = fn(offset, size, f); err != nil {
+			return offset, err
+		}
+		offset += size
+	}
+}
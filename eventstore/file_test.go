@@ -0,0 +1,339 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestFileStore(t *testing.T, dir string, opts ...FileOption) *FileStore {
+	s, err := GetFileStore(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestGetFileStore(t *testing.T) {
+	ctx := context.Background()
+	s := openTestFileStore(t, t.TempDir())
+
+	t.Run("test Load of an unknown aggregate (error)", func(ct *testing.T) {
+		_, err := s.Load(ctx, "agg-id", 0, 0)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+	})
+
+	t.Run("test Save -> Load partial items", func(ct *testing.T) {
+		records := []Record{
+			{Version: 1, Data: []byte("first data")},
+			{Version: 2, Data: []byte("second data")},
+			{Version: 3, Data: []byte("third data")},
+		}
+		err := s.Save(ctx, "partial", 0, records...)
+		assert.Nil(ct, err)
+
+		result, err := s.Load(ctx, "partial", 2, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records[1:]), result)
+
+		result, err = s.Load(ctx, "partial", 0, 2)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records[:2]), result)
+	})
+
+	t.Run("test Save over a stale version (error)", func(ct *testing.T) {
+		err := s.Save(ctx, "stale", 0, Record{Version: 1, Data: []byte("first")})
+		assert.Nil(ct, err)
+
+		err = s.Save(ctx, "stale", 0, Record{Version: 1, Data: []byte("competing")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 1, conflict.ActualVersion)
+	})
+
+	t.Run("test Save with a gap (error)", func(ct *testing.T) {
+		err := s.Save(ctx, "gap", 0, Record{Version: 2, Data: []byte("second")})
+		assert.NotNil(ct, err)
+	})
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir, WithMaxSegmentBytes(64))
+	assert.Nil(t, err)
+	for v := 1; v <= 10; v++ {
+		err = s.Save(ctx, "reopened", v-1, Record{Version: v, Data: []byte("some event data")})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.Close())
+
+	segments, err := listSegments(dir)
+	assert.Nil(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	s = openTestFileStore(t, dir, WithMaxSegmentBytes(64))
+	result, err := s.Load(ctx, "reopened", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(result))
+	assert.Equal(t, 10, result[9].Version)
+
+	err = s.Save(ctx, "reopened", 10, Record{Version: 11, Data: []byte("after reopening")})
+	assert.Nil(t, err)
+}
+
+func TestFileStoreTornWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir)
+	assert.Nil(t, err)
+	err = s.Save(ctx, "torn", 0, Record{Version: 1, Data: []byte("kept")})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	// Simulate a crash halfway through appending the second frame
+	segments, err := listSegments(dir)
+	assert.Nil(t, err)
+	frame := encodeFrame(frameKindRecordsMeta, 2, "torn", []Record{{Version: 2, Data: []byte("lost")}})
+	file, err := os.OpenFile(segments[0].path, os.O_WRONLY|os.O_APPEND, 0o600)
+	assert.Nil(t, err)
+	_, err = file.Write(frame[:len(frame)-3])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	s = openTestFileStore(t, dir)
+	result, err := s.Load(ctx, "torn", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, History{{Version: 1, Data: []byte("kept")}}, result)
+
+	err = s.Save(ctx, "torn", 1, Record{Version: 2, Data: []byte("written again")})
+	assert.Nil(t, err)
+	result, err = s.Load(ctx, "torn", 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, History{{Version: 2, Data: []byte("written again")}}, result)
+}
+
+func TestFileStoreDamagedSegment(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir, WithMaxSegmentBytes(64))
+	assert.Nil(t, err)
+	for v := 1; v <= 5; v++ {
+		err = s.Save(ctx, "damaged", v-1, Record{Version: v, Data: []byte("some event data")})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.Close())
+
+	// Damage in a sealed segment cannot be a torn append, so the store refuses to open
+	segments, err := listSegments(dir)
+	assert.Nil(t, err)
+	data, err := os.ReadFile(segments[0].path)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(segments[0].path, data, 0o600))
+
+	_, err = GetFileStore(dir, WithMaxSegmentBytes(64))
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreCorruptFrame(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir)
+	assert.Nil(t, err)
+	for v := 1; v <= 3; v++ {
+		err = s.Save(ctx, "corrupt", v-1, Record{Version: v, Data: []byte("some event data")})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.Close())
+
+	// A bit flip inside the first frame of the segment being written to is not a torn append
+	segments, err := listSegments(dir)
+	assert.Nil(t, err)
+	data, err := os.ReadFile(segments[0].path)
+	assert.Nil(t, err)
+	data[frameHeaderSize+2] ^= 0x01
+	assert.Nil(t, os.WriteFile(segments[0].path, data, 0o600))
+
+	_, err = GetFileStore(dir)
+	assert.ErrorIs(t, err, errCorruptFrame)
+
+	// The records after the damage are still on disk
+	info, err := os.Stat(segments[0].path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir, WithMaxSegmentBytes(128))
+
+	for v := 1; v <= 10; v++ {
+		assert.Nil(t, s.Save(ctx, "kept", v-1, Record{Version: v, Data: []byte("kept event data")}))
+		assert.Nil(t, s.Save(ctx, "deleted", v-1, Record{Version: v, Data: []byte("deleted event data")}))
+	}
+	assert.Nil(t, s.Delete(ctx, "deleted"))
+
+	_, err := s.Load(ctx, "deleted", 0, 0)
+	assert.NotNil(t, err)
+
+	size := func() int64 {
+		var total int64
+		segments, err := listSegments(dir)
+		assert.Nil(t, err)
+		for _, seg := range segments {
+			info, err := os.Stat(seg.path)
+			assert.Nil(t, err)
+			total += info.Size()
+		}
+		return total
+	}
+	before := size()
+
+	t.Run("test Compact drops deleted streams", func(ct *testing.T) {
+		err := s.Compact(ctx)
+		assert.Nil(ct, err)
+		assert.Less(ct, size(), before)
+
+		result, err := s.Load(ctx, "kept", 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 10, len(result))
+
+		_, err = s.Load(ctx, "deleted", 0, 0)
+		assert.NotNil(ct, err)
+	})
+
+	t.Run("test ReadAll after Compact", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, 10, len(result))
+		for i, record := range result {
+			assert.Equal(ct, "kept", record.AggregateID)
+			assert.Equal(ct, int64(2*i+1), record.Position)
+		}
+	})
+
+	t.Run("test a deleted stream can start over", func(ct *testing.T) {
+		err := s.Save(ctx, "deleted", 0, Record{Version: 1, Data: []byte("new life")})
+		assert.Nil(ct, err)
+
+		result, err := s.Load(ctx, "deleted", 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History{{Version: 1, Data: []byte("new life")}}, result)
+	})
+}
+
+func TestFileStoreInterruptedCompaction(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir, WithMaxSegmentBytes(64))
+	assert.Nil(t, err)
+	for v := 1; v <= 5; v++ {
+		assert.Nil(t, s.Save(ctx, "kept", v-1, Record{Version: v, Data: []byte("kept event data")}))
+	}
+	assert.Nil(t, s.Close())
+
+	// A compaction that never committed leaves only temporary files behind
+	leftover := filepath.Join(dir, segmentName(1)+tempSuffix)
+	assert.Nil(t, os.WriteFile(leftover, []byte("partial"), 0o600))
+
+	s = openTestFileStore(t, dir, WithMaxSegmentBytes(64))
+	_, err = os.Stat(leftover)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	result, err := s.Load(ctx, "kept", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(result))
+}
+
+func TestFileStoreReadAll(t *testing.T) {
+	ctx := context.Background()
+	s := openTestFileStore(t, t.TempDir(), WithSyncPolicy(SyncNever))
+
+	_ = s.Save(ctx, "first", 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+	_ = s.Save(ctx, "second", 0, Record{Version: 1, Data: []byte("second 1")})
+	_ = s.Save(ctx, "first", 2, Record{Version: 3, Data: []byte("first 3")})
+
+	// A rejected write takes no position
+	err := s.Save(ctx, "second", 0, Record{Version: 1, Data: []byte("competing")})
+	assert.NotNil(t, err)
+
+	expected := []GlobalRecord{
+		{Record: Record{Version: 1, Data: []byte("first 1")}, AggregateID: "first", Position: 1},
+		{Record: Record{Version: 2, Data: []byte("first 2")}, AggregateID: "first", Position: 2},
+		{Record: Record{Version: 1, Data: []byte("second 1")}, AggregateID: "second", Position: 3},
+		{Record: Record{Version: 3, Data: []byte("first 3")}, AggregateID: "first", Position: 4},
+	}
+
+	t.Run("test ReadAll from the start", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected, result)
+	})
+
+	t.Run("test ReadAll from a position with a limit", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 2, 2)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected[1:3], result)
+	})
+
+	t.Run("test ReadAll past the end", func(ct *testing.T) {
+		result, err := s.ReadAll(ctx, 5, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, []GlobalRecord{}, result)
+	})
+}
+
+func TestFileStoreMetadata(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := GetFileStore(dir)
+	assert.Nil(t, err)
+	occurredAt := time.Now()
+	record := Record{
+		Version:       1,
+		Data:          []byte("with metadata"),
+		EventType:     "TodoCreated",
+		EventID:       "event-1",
+		OccurredAt:    occurredAt,
+		CorrelationID: "request-1",
+		CausationID:   "command-1",
+		Headers:       map[string]string{"tenant": "acme", "source": "cli"},
+	}
+	assert.Nil(t, s.Save(ctx, "meta", 0, record))
+	assert.Nil(t, s.Close())
+
+	// Frames written before records had metadata remain readable
+	segments, err := listSegments(dir)
+	assert.Nil(t, err)
+	file, err := os.OpenFile(segments[0].path, os.O_WRONLY|os.O_APPEND, 0o600)
+	assert.Nil(t, err)
+	_, err = file.Write(encodeFrame(frameKindRecords, 2, "meta", []Record{{Version: 2, Data: []byte("without metadata")}}))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	s = openTestFileStore(t, dir)
+	result, err := s.Load(ctx, "meta", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(result))
+
+	assert.True(t, occurredAt.Equal(result[0].OccurredAt))
+	result[0].OccurredAt = occurredAt
+	assert.Equal(t, record, result[0])
+	assert.Equal(t, Record{Version: 2, Data: []byte("without metadata")}, result[1])
+}
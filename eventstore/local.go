@@ -2,38 +2,80 @@ package eventstore
 
 import (
 	"context"
-	"fmt"
-	"sort"
 	"sync"
 )
 
+// memoryEventStore keeps records in maps and slices guarded by mux. Records are copied on the way in and
+// on the way out, so callers can never reach the store's own copy.
 type memoryEventStore struct {
-	mux        *sync.Mutex
+	mux        *sync.RWMutex
 	eventsByID map[string]History
+	all        []GlobalRecord
 }
 
-func (m *memoryEventStore) Save(_ context.Context, aggregateID string, records ...Record) error {
-	if _, ok := m.eventsByID[aggregateID]; !ok {
-		m.eventsByID[aggregateID] = History{}
+func (m *memoryEventStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	return m.SaveAll(ctx, Append{AggregateID: aggregateID, ExpectedVersion: expectedVersion, Records: records})
+}
+
+// SaveAll implements the MultiStreamSaver interface
+func (m *memoryEventStore) SaveAll(_ context.Context, appends ...Append) error {
+	if err := checkAppends(appends); err != nil {
+		return err
 	}
 
-	m.eventsByID[aggregateID] = append(m.eventsByID[aggregateID], records...)
-	sort.Sort(m.eventsByID[aggregateID])
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for _, a := range appends {
+		if len(a.Records) == 0 {
+			continue
+		}
+		current := m.eventsByID[a.AggregateID]
+		actualVersion := 0
+		if len(current) > 0 {
+			actualVersion = current[len(current)-1].Version
+		}
+		if actualVersion != a.ExpectedVersion {
+			return &ErrConcurrencyConflict{
+				AggregateID:     a.AggregateID,
+				ExpectedVersion: a.ExpectedVersion,
+				ActualVersion:   actualVersion,
+			}
+		}
+	}
+
+	for _, a := range appends {
+		if len(a.Records) == 0 {
+			continue
+		}
+		for _, record := range a.Records {
+			record = cloneRecord(record)
+			m.eventsByID[a.AggregateID] = append(m.eventsByID[a.AggregateID], record)
+			m.all = append(m.all, GlobalRecord{
+				Record:      record,
+				AggregateID: a.AggregateID,
+				Position:    int64(len(m.all) + 1),
+			})
+		}
+	}
 
 	return nil
 }
 
 func (m *memoryEventStore) Load(_ context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
 	all, ok := m.eventsByID[aggregateID]
 	if !ok {
-		return nil, fmt.Errorf("no aggregate found with id, %v", aggregateID)
+		return nil, notFound(aggregateID)
 	}
 
 	history := make(History, 0, len(all))
 	if len(all) > 0 {
 		for _, record := range all {
 			if v := record.Version; v >= fromVersion && (toVersion == 0 || v <= toVersion) {
-				history = append(history, record)
+				history = append(history, cloneRecord(record))
 			}
 		}
 	}
@@ -41,10 +83,51 @@ func (m *memoryEventStore) Load(_ context.Context, aggregateID string, fromVersi
 	return history, nil
 }
 
+// ReadAll implements the GlobalReader interface
+func (m *memoryEventStore) ReadAll(_ context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	if fromPosition < 1 {
+		fromPosition = 1
+	}
+	if fromPosition > int64(len(m.all)) {
+		return []GlobalRecord{}, nil
+	}
+
+	// Positions are assigned without gaps, so a record's position is its index + 1
+	rest := m.all[fromPosition-1:]
+	if limit > 0 && limit < len(rest) {
+		rest = rest[:limit]
+	}
+	result := make([]GlobalRecord, 0, len(rest))
+	for _, record := range rest {
+		record.Record = cloneRecord(record.Record)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// cloneRecord returns a copy of a record that shares no memory with it
+func cloneRecord(record Record) Record {
+	if record.Data != nil {
+		record.Data = append([]byte{}, record.Data...)
+	}
+	if record.Headers != nil {
+		headers := make(map[string]string, len(record.Headers))
+		for k, v := range record.Headers {
+			headers[k] = v
+		}
+		record.Headers = headers
+	}
+	return record
+}
+
 // GetLocalStore returns an EventStore in memory - good for tests!
+// It is safe for concurrent use, and it also implements GlobalReader and MultiStreamSaver.
 func GetLocalStore() EventStore {
 	return &memoryEventStore{
-		mux:        &sync.Mutex{},
+		mux:        &sync.RWMutex{},
 		eventsByID: map[string]History{},
 	}
 }
@@ -0,0 +1,269 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStoreReadAll(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalStore()
+	reader, ok := s.(GlobalReader)
+	assert.True(t, ok)
+
+	_ = s.Save(ctx, "first", 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+	_ = s.Save(ctx, "second", 0, Record{Version: 1, Data: []byte("second 1")})
+	_ = s.Save(ctx, "first", 2, Record{Version: 3, Data: []byte("first 3")})
+
+	// A rejected write takes no position
+	err := s.Save(ctx, "second", 0, Record{Version: 1, Data: []byte("competing")})
+	assert.NotNil(t, err)
+
+	expected := []GlobalRecord{
+		{Record: Record{Version: 1, Data: []byte("first 1")}, AggregateID: "first", Position: 1},
+		{Record: Record{Version: 2, Data: []byte("first 2")}, AggregateID: "first", Position: 2},
+		{Record: Record{Version: 1, Data: []byte("second 1")}, AggregateID: "second", Position: 3},
+		{Record: Record{Version: 3, Data: []byte("first 3")}, AggregateID: "first", Position: 4},
+	}
+
+	t.Run("test ReadAll from the start", func(ct *testing.T) {
+		result, err := reader.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected, result)
+	})
+
+	t.Run("test ReadAll from a position with a limit", func(ct *testing.T) {
+		result, err := reader.ReadAll(ctx, 2, 2)
+		assert.Nil(ct, err)
+		assert.Equal(ct, expected[1:3], result)
+	})
+
+	t.Run("test ReadAll past the end", func(ct *testing.T) {
+		result, err := reader.ReadAll(ctx, 5, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, []GlobalRecord{}, result)
+	})
+}
+
+func TestLocalSnapshotStore(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalSnapshotStore()
+
+	t.Run("test LoadSnapshot without a snapshot", func(ct *testing.T) {
+		snapshot, err := s.LoadSnapshot(ctx, "agg-id")
+		assert.Nil(ct, err)
+		assert.Nil(ct, snapshot)
+	})
+
+	t.Run("test SaveSnapshot -> LoadSnapshot keeps the newest", func(ct *testing.T) {
+		newest := Snapshot{AggregateID: "agg-id", Version: 10, Data: []byte("state at 10")}
+		assert.Nil(ct, s.SaveSnapshot(ctx, Snapshot{AggregateID: "agg-id", Version: 5, Data: []byte("state at 5")}))
+		assert.Nil(ct, s.SaveSnapshot(ctx, newest))
+		assert.Nil(ct, s.SaveSnapshot(ctx, Snapshot{AggregateID: "agg-id", Version: 7, Data: []byte("state at 7")}))
+
+		snapshot, err := s.LoadSnapshot(ctx, "agg-id")
+		assert.Nil(ct, err)
+		assert.Equal(ct, &newest, snapshot)
+	})
+}
+
+func TestLocalStoreSaveAll(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalStore()
+	saver, ok := s.(MultiStreamSaver)
+	assert.True(t, ok)
+
+	_ = s.Save(ctx, "from", 0, Record{Version: 1, Data: []byte("opened")})
+
+	t.Run("test SaveAll to several streams", func(ct *testing.T) {
+		err := saver.SaveAll(ctx,
+			Append{AggregateID: "from", ExpectedVersion: 1, Records: []Record{{Version: 2, Data: []byte("debited")}}},
+			Append{AggregateID: "to", ExpectedVersion: 0, Records: []Record{{Version: 1, Data: []byte("credited")}}},
+		)
+		assert.Nil(ct, err)
+
+		from, _ := s.Load(ctx, "from", 0, 0)
+		assert.Len(ct, from, 2)
+		to, _ := s.Load(ctx, "to", 0, 0)
+		assert.Len(ct, to, 1)
+	})
+
+	t.Run("test SaveAll with one stale stream writes nothing (error)", func(ct *testing.T) {
+		err := saver.SaveAll(ctx,
+			Append{AggregateID: "from", ExpectedVersion: 2, Records: []Record{{Version: 3, Data: []byte("debited")}}},
+			Append{AggregateID: "to", ExpectedVersion: 0, Records: []Record{{Version: 1, Data: []byte("credited")}}},
+		)
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, "to", conflict.AggregateID)
+		assert.Equal(ct, 1, conflict.ActualVersion)
+
+		from, _ := s.Load(ctx, "from", 0, 0)
+		assert.Len(ct, from, 2)
+	})
+
+	t.Run("test SaveAll with the same stream twice (error)", func(ct *testing.T) {
+		err := saver.SaveAll(ctx,
+			Append{AggregateID: "from", ExpectedVersion: 2, Records: []Record{{Version: 3}}},
+			Append{AggregateID: "from", ExpectedVersion: 3, Records: []Record{{Version: 4}}},
+		)
+		assert.NotNil(ct, err)
+	})
+}
+
+func TestLocalStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalStore()
+	reader := s.(GlobalReader)
+	saver := s.(MultiStreamSaver)
+
+	const writers, appendsPerWriter = 8, 50
+	var wg sync.WaitGroup
+
+	// Every writer appends to a shared stream by reading its version and retrying on conflicts,
+	// and to a stream of its own together with the shared one now and then
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			own := fmt.Sprintf("own-%d", w)
+			for i := 0; i < appendsPerWriter; {
+				version := 0
+				if history, err := s.Load(ctx, "shared", 0, 0); err == nil {
+					version = history[len(history)-1].Version
+				}
+				record := Record{Version: version + 1, Data: []byte(fmt.Sprintf("writer %d event %d", w, i))}
+
+				var err error
+				if i%5 == 0 {
+					err = saver.SaveAll(ctx,
+						Append{AggregateID: "shared", ExpectedVersion: version, Records: []Record{record}},
+						Append{AggregateID: own, ExpectedVersion: i / 5, Records: []Record{{Version: i/5 + 1}}},
+					)
+				} else {
+					err = s.Save(ctx, "shared", version, record)
+				}
+
+				var conflict *ErrConcurrencyConflict
+				if errors.As(err, &conflict) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				i++
+			}
+		}(w)
+	}
+
+	// Readers read while the writers write
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < appendsPerWriter; i++ {
+				if history, err := s.Load(ctx, "shared", 0, 0); err == nil {
+					for i, record := range history {
+						if record.Version != i+1 {
+							t.Errorf("version %d at index %d", record.Version, i)
+						}
+					}
+				}
+				if _, err := reader.ReadAll(ctx, 0, 0); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	t.Run("test every append landed once and in order", func(ct *testing.T) {
+		history, err := s.Load(ctx, "shared", 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, history, writers*appendsPerWriter)
+		for i, record := range history {
+			assert.Equal(ct, i+1, record.Version)
+		}
+
+		for w := 0; w < writers; w++ {
+			own, err := s.Load(ctx, fmt.Sprintf("own-%d", w), 0, 0)
+			assert.Nil(ct, err)
+			assert.Len(ct, own, appendsPerWriter/5)
+		}
+	})
+
+	t.Run("test ReadAll has every record at a distinct position", func(ct *testing.T) {
+		all, err := reader.ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, all, writers*appendsPerWriter+writers*appendsPerWriter/5)
+		for i, record := range all {
+			assert.Equal(ct, int64(i+1), record.Position)
+		}
+	})
+}
+
+func TestLocalStoreCopies(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalStore()
+	reader := s.(GlobalReader)
+
+	record := Record{Version: 1, Data: []byte("original"), Headers: map[string]string{"tenant": "acme"}}
+	assert.Nil(t, s.Save(ctx, "copied", 0, record))
+
+	t.Run("test changing a saved record does not change the store", func(ct *testing.T) {
+		record.Data[0] = 'X'
+		record.Headers["tenant"] = "changed"
+
+		history, err := s.Load(ctx, "copied", 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, []byte("original"), history[0].Data)
+		assert.Equal(ct, "acme", history[0].Headers["tenant"])
+	})
+
+	t.Run("test changing a loaded record does not change the store", func(ct *testing.T) {
+		history, _ := s.Load(ctx, "copied", 0, 0)
+		history[0].Data[0] = 'X'
+		history[0].Headers["tenant"] = "changed"
+
+		all, _ := reader.ReadAll(ctx, 0, 0)
+		all[0].Data[1] = 'X'
+
+		history, err := s.Load(ctx, "copied", 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, []byte("original"), history[0].Data)
+		assert.Equal(ct, "acme", history[0].Headers["tenant"])
+	})
+
+	t.Run("test Save of a version that exists (error)", func(ct *testing.T) {
+		err := s.Save(ctx, "copied", 0, Record{Version: 1, Data: []byte("duplicate")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+
+		err = s.Save(ctx, "copied", 1, Record{Version: 2}, Record{Version: 2})
+		assert.NotNil(ct, err)
+	})
+}
+
+func TestLocalStoreLoad(t *testing.T) {
+	ctx := context.Background()
+	s := GetLocalStore()
+	_ = s.Save(ctx, "existing", 0, Record{Version: 1, Data: []byte("first")})
+
+	t.Run("test Load of an unknown aggregate (error)", func(ct *testing.T) {
+		_, err := s.Load(ctx, "unknown", 0, 0)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+	})
+
+	t.Run("test Load past the end of a stream", func(ct *testing.T) {
+		result, err := s.Load(ctx, "existing", 2, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 0)
+	})
+}
@@ -0,0 +1,69 @@
+package eventstore
+
+import (
+	"context"
+
+	"github.com/cannahum/eventsourcing-lite/metrics"
+)
+
+// metricsStore counts the events saved to another store
+type metricsStore struct {
+	store    EventStore
+	recorder metrics.Recorder
+	backend  string
+}
+
+// GetMetricsStore wraps a store so that the events it saves are counted by type with recorder.
+// The wrapper implements GlobalReader and MultiStreamSaver when store does.
+func GetMetricsStore(store EventStore, recorder metrics.Recorder) EventStore {
+	return wrap(&metricsStore{
+		store:    store,
+		recorder: recorder,
+		backend:  Backend(store),
+	})
+}
+
+func (m *metricsStore) wrapped() EventStore {
+	return m.store
+}
+
+func (m *metricsStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	err := m.store.Save(ctx, aggregateID, expectedVersion, records...)
+	if err == nil {
+		m.appended(records)
+	}
+	return err
+}
+
+func (m *metricsStore) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	return m.store.Load(ctx, aggregateID, fromVersion, toVersion)
+}
+
+func (m *metricsStore) saveAll(ctx context.Context, appends ...Append) error {
+	err := m.store.(MultiStreamSaver).SaveAll(ctx, appends...)
+	if err == nil {
+		for _, a := range appends {
+			m.appended(a.Records)
+		}
+	}
+	return err
+}
+
+func (m *metricsStore) readAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	return m.store.(GlobalReader).ReadAll(ctx, fromPosition, limit)
+}
+
+// appended records saved records, one measurement per event type
+func (m *metricsStore) appended(records []Record) {
+	counts := map[string]int{}
+	var order []string
+	for _, record := range records {
+		if counts[record.EventType] == 0 {
+			order = append(order, record.EventType)
+		}
+		counts[record.EventType]++
+	}
+	for _, eventType := range order {
+		m.recorder.EventsAppended(m.backend, eventType, counts[eventType])
+	}
+}
@@ -0,0 +1,83 @@
+package eventstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/cannahum/eventsourcing-lite/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRecorder is a metrics.Recorder that counts appended events and failed conditions
+type countingRecorder struct {
+	metrics.Nop
+	mux          sync.Mutex
+	appended     map[string]int
+	checksFailed int
+}
+
+func (c *countingRecorder) EventsAppended(backend, eventType string, count int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.appended == nil {
+		c.appended = map[string]int{}
+	}
+	c.appended[backend+"/"+eventType] += count
+}
+
+func (c *countingRecorder) ConditionalCheckFailed(string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.checksFailed++
+}
+
+func TestMetricsStore(t *testing.T) {
+	ctx := context.Background()
+	recorder := &countingRecorder{}
+	s := GetMetricsStore(GetLocalStore(), recorder)
+
+	t.Run("test saved events are counted by type", func(ct *testing.T) {
+		err := s.Save(ctx, "counted", 0,
+			Record{Version: 1, EventType: "TodoCreated"},
+			Record{Version: 2, EventType: "TodoDone"},
+			Record{Version: 3, EventType: "TodoUndone"},
+			Record{Version: 4, EventType: "TodoDone"},
+		)
+		assert.Nil(ct, err)
+
+		err = s.(MultiStreamSaver).SaveAll(ctx,
+			Append{AggregateID: "counted", ExpectedVersion: 4, Records: []Record{{Version: 5, EventType: "TodoUndone"}}},
+			Append{AggregateID: "other", ExpectedVersion: 0, Records: []Record{{Version: 1, EventType: "TodoCreated"}}},
+		)
+		assert.Nil(ct, err)
+
+		assert.Equal(ct, map[string]int{
+			"memory/TodoCreated": 2,
+			"memory/TodoDone":    2,
+			"memory/TodoUndone":  2,
+		}, recorder.appended)
+	})
+
+	t.Run("test rejected events are not counted", func(ct *testing.T) {
+		err := s.Save(ctx, "counted", 0, Record{Version: 1, EventType: "TodoCreated"})
+		assert.NotNil(ct, err)
+		assert.Equal(ct, 2, recorder.appended["memory/TodoCreated"])
+	})
+
+	t.Run("test the wrapper only implements what the store does", func(ct *testing.T) {
+		_, ok := s.(GlobalReader)
+		assert.True(ct, ok)
+
+		plain := GetMetricsStore(struct{ EventStore }{GetLocalStore()}, recorder)
+		_, ok = plain.(MultiStreamSaver)
+		assert.False(ct, ok)
+		_, ok = plain.(GlobalReader)
+		assert.False(ct, ok)
+	})
+
+	t.Run("test Backend sees through the wrapper", func(ct *testing.T) {
+		assert.Equal(ct, "memory", Backend(s))
+		assert.Equal(ct, "memory", Backend(GetTracingStore(s, nil)))
+	})
+}
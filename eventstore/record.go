@@ -1,9 +1,34 @@
 package eventstore
 
-// Record represents the event in serialized form
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Record represents the event in serialized form, along with metadata that stores keep outside of Data
 type Record struct {
 	Version int
 	Data    []byte `dynamodbav:"event_data"`
+
+	// EventType contains the unique name of the event's type
+	EventType string `dynamodbav:"event_type,omitempty"`
+
+	// EventID contains the unique id of the event
+	EventID string `dynamodbav:"event_id,omitempty"`
+
+	// OccurredAt indicates when the event occurred
+	OccurredAt time.Time `dynamodbav:"occurred_at,omitempty"`
+
+	// CorrelationID contains the id shared by every event that resulted from the same request
+	CorrelationID string `dynamodbav:"correlation_id,omitempty"`
+
+	// CausationID contains the id of the message that caused the event
+	CausationID string `dynamodbav:"causation_id,omitempty"`
+
+	// Headers contains free-form metadata
+	Headers map[string]string `dynamodbav:"headers,omitempty"`
 }
 
 // History represents
@@ -23,3 +48,54 @@ func (h History) Swap(i, j int) {
 func (h History) Less(i, j int) bool {
 	return h[i].Version < h[j].Version
 }
+
+// checkVersions sorts the records and makes sure they continue a stream at expectedVersion without gaps
+func checkVersions(expectedVersion int, records []Record) error {
+	if expectedVersion < 0 {
+		return fmt.Errorf("invalid expected version %d", expectedVersion)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+
+	for i := len(records) - 2; i >= 0; i-- {
+		if records[i].Version == records[i+1].Version {
+			return errors.New("duplicate version detected")
+		}
+	}
+
+	for i, record := range records {
+		if record.Version != expectedVersion+i+1 {
+			return fmt.Errorf("record version %d does not follow version %d", record.Version, expectedVersion+i)
+		}
+	}
+	return nil
+}
+
+// checkAppends checks the records of every stream like checkVersions does and makes sure no stream appears twice
+func checkAppends(appends []Append) error {
+	seen := map[string]bool{}
+	for _, a := range appends {
+		if seen[a.AggregateID] {
+			return fmt.Errorf("stream %s appears more than once", a.AggregateID)
+		}
+		seen[a.AggregateID] = true
+
+		if err := checkVersions(a.ExpectedVersion, a.Records); err != nil {
+			return fmt.Errorf("stream %s: %w", a.AggregateID, err)
+		}
+	}
+	return nil
+}
+
+// GlobalRecord is a Record along with the aggregate it belongs to and its place in the store's global log
+type GlobalRecord struct {
+	Record
+
+	// AggregateID contains the id of the aggregate the record belongs to
+	AggregateID string
+
+	// Position contains the place of the record in commit order across all aggregates, starting at 1
+	Position int64
+}
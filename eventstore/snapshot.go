@@ -0,0 +1,69 @@
+package eventstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Snapshot is the serialized state of an aggregate as of a specific version
+type Snapshot struct {
+	// AggregateID contains the id of the aggregate the state belongs to
+	AggregateID string
+
+	// Version contains the version of the last event the state includes
+	Version int
+
+	// Data contains the serialized state
+	Data []byte
+
+	// At indicates when the snapshot was taken
+	At time.Time
+}
+
+// SnapshotStore provides an abstraction for the Repository to save aggregate state, so loading an aggregate
+// only has to replay the events that followed its newest snapshot
+type SnapshotStore interface {
+	// SaveSnapshot stores a snapshot. A snapshot older than the newest one stored is never returned again.
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+
+	// LoadSnapshot returns the newest snapshot of an aggregate, or nil when it has none
+	LoadSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error)
+}
+
+type memorySnapshotStore struct {
+	mux          *sync.Mutex
+	snapshotByID map[string]Snapshot
+}
+
+func (m *memorySnapshotStore) SaveSnapshot(_ context.Context, snapshot Snapshot) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if current, ok := m.snapshotByID[snapshot.AggregateID]; ok && current.Version >= snapshot.Version {
+		return nil
+	}
+	snapshot.Data = append([]byte{}, snapshot.Data...)
+	m.snapshotByID[snapshot.AggregateID] = snapshot
+	return nil
+}
+
+func (m *memorySnapshotStore) LoadSnapshot(_ context.Context, aggregateID string) (*Snapshot, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	snapshot, ok := m.snapshotByID[aggregateID]
+	if !ok {
+		return nil, nil
+	}
+	snapshot.Data = append([]byte{}, snapshot.Data...)
+	return &snapshot, nil
+}
+
+// GetLocalSnapshotStore returns a SnapshotStore in memory - good for tests!
+func GetLocalSnapshotStore() SnapshotStore {
+	return &memorySnapshotStore{
+		mux:          &sync.Mutex{},
+		snapshotByID: map[string]Snapshot{},
+	}
+}
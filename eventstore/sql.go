@@ -0,0 +1,344 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SQLDialect describes how the SQL store talks to a specific database
+type SQLDialect struct {
+	name        string
+	placeholder func(n int) string
+	lock        string
+	migrations  [][]string
+}
+
+// PostgresDialect targets PostgreSQL through a driver such as pgx or lib/pq.
+// Writers take a transaction-scoped advisory lock, so positions in the global log follow commit order.
+var PostgresDialect = SQLDialect{
+	name: "postgres",
+	placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	lock: "SELECT pg_advisory_xact_lock(hashtext('{table}'))",
+	migrations: [][]string{
+		{
+			`CREATE TABLE IF NOT EXISTS {table} (
+				position BIGSERIAL PRIMARY KEY,
+				aggregate_id VARCHAR(255) NOT NULL,
+				version INTEGER NOT NULL,
+				data BYTEA NOT NULL,
+				CONSTRAINT {table}_stream UNIQUE (aggregate_id, version)
+			)`,
+		},
+		{
+			`ALTER TABLE {table}
+				ADD COLUMN event_type VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN event_id VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN occurred_at TIMESTAMPTZ,
+				ADD COLUMN correlation_id VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN causation_id VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN headers JSONB`,
+		},
+	},
+}
+
+// SQLiteDialect targets SQLite; SQLite runs one writer at a time, so positions follow commit order
+var SQLiteDialect = SQLDialect{
+	name: "sqlite",
+	placeholder: func(int) string {
+		return "?"
+	},
+	migrations: [][]string{
+		{
+			`CREATE TABLE IF NOT EXISTS {table} (
+				position INTEGER PRIMARY KEY AUTOINCREMENT,
+				aggregate_id TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				data BLOB NOT NULL,
+				CONSTRAINT {table}_stream UNIQUE (aggregate_id, version)
+			)`,
+		},
+		{
+			// SQLite adds one column per statement
+			`ALTER TABLE {table} ADD COLUMN event_type TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE {table} ADD COLUMN event_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE {table} ADD COLUMN occurred_at DATETIME`,
+			`ALTER TABLE {table} ADD COLUMN correlation_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE {table} ADD COLUMN causation_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE {table} ADD COLUMN headers TEXT`,
+		},
+	},
+}
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore is an event store implementation on top of database/sql.
+// Every record is a row keyed by its position; a unique constraint on (aggregate_id, version) backs up the
+// version check in Save, so two writers can never both append the same version.
+type SQLStore struct {
+	db        *sql.DB
+	dialect   SQLDialect
+	tableName string
+}
+
+// GetSQLStore returns a new SQL store instance; call Migrate before first use to create its tables
+func GetSQLStore(db *sql.DB, dialect SQLDialect, tableName string) (*SQLStore, error) {
+	if !tableNamePattern.MatchString(tableName) {
+		return nil, fmt.Errorf("invalid table name %q", tableName)
+	}
+	return &SQLStore{
+		db:        db,
+		dialect:   dialect,
+		tableName: tableName,
+	}, nil
+}
+
+// query replaces the {table} and {n} markers of a statement with the table name and the dialect's placeholders
+func (s *SQLStore) query(statement string) string {
+	statement = strings.ReplaceAll(statement, "{table}", s.tableName)
+	for n := 1; strings.Contains(statement, "{"+strconv.Itoa(n)+"}"); n++ {
+		statement = strings.ReplaceAll(statement, "{"+strconv.Itoa(n)+"}", s.dialect.placeholder(n))
+	}
+	return statement
+}
+
+// Migrate brings the store's tables up to date; migrations already applied are skipped
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.query(`CREATE TABLE IF NOT EXISTS {table}_migrations (version INTEGER PRIMARY KEY)`))
+	if err != nil {
+		return err
+	}
+
+	var applied int
+	err = s.db.QueryRowContext(ctx, s.query(`SELECT COALESCE(MAX(version), 0) FROM {table}_migrations`)).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	for i := applied; i < len(s.dialect.migrations); i++ {
+		if err = s.migrate(ctx, i+1, s.dialect.migrations[i]); err != nil {
+			return fmt.Errorf("unable to apply %s migration %d: %w", s.dialect.name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) migrate(ctx context.Context, version int, statements []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op once committed
+	}()
+
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, s.query(statement)); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, s.query(`INSERT INTO {table}_migrations (version) VALUES ({1})`), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Save implements the EventStore interface and inserts the records in a single transaction
+func (s *SQLStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := checkVersions(expectedVersion, records); err != nil {
+		return err
+	}
+
+	err := s.insert(ctx, aggregateID, expectedVersion, records)
+	if err == nil {
+		return nil
+	}
+	var conflict *ErrConcurrencyConflict
+	if errors.As(err, &conflict) {
+		return err
+	}
+
+	// The insert failed; when the stream moved meanwhile, it was the unique constraint that stopped it
+	actualVersion, versionErr := s.currentVersion(ctx, s.db, aggregateID)
+	if versionErr == nil && actualVersion != expectedVersion {
+		return &ErrConcurrencyConflict{
+			AggregateID:     aggregateID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   actualVersion,
+		}
+	}
+	return err
+}
+
+func (s *SQLStore) insert(ctx context.Context, aggregateID string, expectedVersion int, records []Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op once committed
+	}()
+
+	if s.dialect.lock != "" {
+		if _, err = tx.ExecContext(ctx, s.query(s.dialect.lock)); err != nil {
+			return err
+		}
+	}
+
+	actualVersion, err := s.currentVersion(ctx, tx, aggregateID)
+	if err != nil {
+		return err
+	}
+	if actualVersion != expectedVersion {
+		return &ErrConcurrencyConflict{
+			AggregateID:     aggregateID,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   actualVersion,
+		}
+	}
+
+	statement := s.query(`INSERT INTO {table} (
+		aggregate_id, version, data, event_type, event_id, occurred_at, correlation_id, causation_id, headers
+	) VALUES ({1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9})`)
+	for _, record := range records {
+		occurredAt := sql.NullTime{Time: record.OccurredAt, Valid: !record.OccurredAt.IsZero()}
+		var headers sql.NullString
+		if len(record.Headers) > 0 {
+			data, marshalErr := json.Marshal(record.Headers)
+			if marshalErr != nil {
+				return marshalErr
+			}
+			headers = sql.NullString{String: string(data), Valid: true}
+		}
+
+		_, err = tx.ExecContext(
+			ctx, statement,
+			aggregateID, record.Version, record.Data,
+			record.EventType, record.EventID, occurredAt, record.CorrelationID, record.CausationID, headers,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordColumns lists the columns scanRecord reads, in order
+const recordColumns = `version, data, event_type, event_id, occurred_at, correlation_id, causation_id, headers`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecord reads the recordColumns of a row, after the columns that come before them
+func scanRecord(row rowScanner, record *Record, before ...interface{}) error {
+	var occurredAt sql.NullTime
+	var headers sql.NullString
+	dest := append(before,
+		&record.Version, &record.Data, &record.EventType, &record.EventID,
+		&occurredAt, &record.CorrelationID, &record.CausationID, &headers,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	if occurredAt.Valid {
+		record.OccurredAt = occurredAt.Time
+	}
+	if headers.Valid {
+		return json.Unmarshal([]byte(headers.String), &record.Headers)
+	}
+	return nil
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// currentVersion reads the version of the last record of a stream; 0 when the stream is empty
+func (s *SQLStore) currentVersion(ctx context.Context, q queryRower, aggregateID string) (int, error) {
+	var version int
+	err := q.QueryRowContext(
+		ctx,
+		s.query(`SELECT COALESCE(MAX(version), 0) FROM {table} WHERE aggregate_id = {1}`),
+		aggregateID,
+	).Scan(&version)
+	return version, err
+}
+
+// Load implements the EventStore interface and reads the events of a specific aggregateID in version order
+func (s *SQLStore) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	statement := `SELECT ` + recordColumns + ` FROM {table} WHERE aggregate_id = {1} AND version >= {2}`
+	args := []interface{}{aggregateID, fromVersion}
+	if toVersion > 0 {
+		statement += ` AND version <= {3}`
+		args = append(args, toVersion)
+	}
+	statement += ` ORDER BY version`
+
+	rows, err := s.db.QueryContext(ctx, s.query(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := History{}
+	for rows.Next() {
+		var record Record
+		if err = scanRecord(rows, &record); err != nil {
+			return nil, err
+		}
+		history = append(history, record)
+	}
+	if err = rows.Err(); err != nil || len(history) > 0 {
+		return history, err
+	}
+
+	// Versions start at 1, so nothing from the start means no stream; past it, the stream may still exist
+	if fromVersion > 1 {
+		var version int
+		if version, err = s.currentVersion(ctx, s.db, aggregateID); err != nil || version > 0 {
+			return history, err
+		}
+	}
+	return nil, notFound(aggregateID)
+}
+
+// ReadAll implements the GlobalReader interface
+func (s *SQLStore) ReadAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	statement := `SELECT position, aggregate_id, ` + recordColumns + ` FROM {table} WHERE position >= {1} ORDER BY position`
+	args := []interface{}{fromPosition}
+	if limit > 0 {
+		statement += ` LIMIT {2}`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.query(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]GlobalRecord, 0, limit)
+	for rows.Next() {
+		var record GlobalRecord
+		if err = scanRecord(rows, &record.Record, &record.Position, &record.AggregateID); err != nil {
+			return nil, err
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
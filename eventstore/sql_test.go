@@ -0,0 +1,181 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+)
+
+func openTestSQLStore(t *testing.T) *SQLStore {
+	dsn := "file:" + filepath.Join(t.TempDir(), "events.db") + "?_pragma=busy_timeout(5000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	s, err := GetSQLStore(db, SQLiteDialect, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGetSQLStore(t *testing.T) {
+	_, err := GetSQLStore(nil, SQLiteDialect, "events; DROP TABLE events")
+	assert.NotNil(t, err)
+
+	s := openTestSQLStore(t)
+	ctx := context.Background()
+
+	t.Run("test Migrate twice", func(ct *testing.T) {
+		err := s.Migrate(ctx)
+		assert.Nil(ct, err)
+	})
+
+	t.Run("test Load of an unknown aggregate (error)", func(ct *testing.T) {
+		result, err := s.Load(ctx, "agg-id", 0, 0)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+		assert.Nil(ct, result)
+	})
+
+	t.Run("test Save -> Load partial items", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		records := []Record{
+			{Version: 1, Data: []byte("first data")},
+			{Version: 2, Data: []byte("second data")},
+			{Version: 3, Data: []byte("third data")},
+			{Version: 4, Data: []byte("fourth data")},
+		}
+
+		err := s.Save(ctx, aggID, 0, records...)
+		assert.Nil(ct, err)
+
+		readVersion, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records), readVersion)
+
+		secondToThird, err := s.Load(ctx, aggID, 2, 3)
+		assert.Nil(ct, err)
+		assert.Equal(ct, History(records[1:3]), secondToThird)
+	})
+
+	t.Run("test Load past the end of a stream", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		_ = s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first data")})
+
+		result, err := s.Load(ctx, aggID, 2, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 0)
+
+		_, err = s.Load(ctx, uuid.NewV4().String(), 2, 0)
+		assert.True(ct, errors.Is(err, ErrAggregateNotFound))
+	})
+
+	t.Run("test Save -> Load with metadata", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		occurredAt := time.Now()
+		record := Record{
+			Version:       1,
+			Data:          []byte("with metadata"),
+			EventType:     "TodoCreated",
+			EventID:       uuid.NewV4().String(),
+			OccurredAt:    occurredAt,
+			CorrelationID: "request-1",
+			CausationID:   "command-1",
+			Headers:       map[string]string{"tenant": "acme"},
+		}
+		err := s.Save(ctx, aggID, 0, record)
+		assert.Nil(ct, err)
+
+		result, err := s.Load(ctx, aggID, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 1)
+		assert.True(ct, occurredAt.Equal(result[0].OccurredAt))
+		result[0].OccurredAt = occurredAt
+		assert.Equal(ct, record, result[0])
+	})
+
+	t.Run("test Save with stale expected version (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		err := s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("first data")})
+		assert.Nil(ct, err)
+
+		err = s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("competing data")})
+		var conflict *ErrConcurrencyConflict
+		assert.True(ct, errors.As(err, &conflict))
+		assert.Equal(ct, 0, conflict.ExpectedVersion)
+		assert.Equal(ct, 1, conflict.ActualVersion)
+	})
+
+	t.Run("test Save - records with a gap (error)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+		err := s.Save(ctx, aggID, 0, Record{Version: 1}, Record{Version: 3})
+		assert.NotNil(ct, err)
+	})
+
+	t.Run("test Save concurrently (only one succeeds)", func(ct *testing.T) {
+		aggID := uuid.NewV4().String()
+
+		const writers = 8
+		var wg sync.WaitGroup
+		results := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- s.Save(ctx, aggID, 0, Record{Version: 1, Data: []byte("racing")})
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			var conflict *ErrConcurrencyConflict
+			if err == nil {
+				succeeded++
+			} else {
+				assert.True(ct, errors.As(err, &conflict))
+			}
+		}
+		assert.Equal(ct, 1, succeeded)
+	})
+}
+
+func TestSQLStoreReadAll(t *testing.T) {
+	s := openTestSQLStore(t)
+	ctx := context.Background()
+
+	_ = s.Save(ctx, "first", 0, Record{Version: 1, Data: []byte("first 1")}, Record{Version: 2, Data: []byte("first 2")})
+	_ = s.Save(ctx, "second", 0, Record{Version: 1, Data: []byte("second 1")})
+	_ = s.Save(ctx, "first", 2, Record{Version: 3, Data: []byte("first 3")})
+
+	expected := []GlobalRecord{
+		{Record: Record{Version: 1, Data: []byte("first 1")}, AggregateID: "first", Position: 1},
+		{Record: Record{Version: 2, Data: []byte("first 2")}, AggregateID: "first", Position: 2},
+		{Record: Record{Version: 1, Data: []byte("second 1")}, AggregateID: "second", Position: 3},
+		{Record: Record{Version: 3, Data: []byte("first 3")}, AggregateID: "first", Position: 4},
+	}
+
+	result, err := s.ReadAll(ctx, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	result, err = s.ReadAll(ctx, 2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[1:3], result)
+}
@@ -4,11 +4,41 @@ import "context"
 
 // EventStore provides an abstraction for the Repository to save data
 type EventStore interface {
-	// Save the provided serialized records to the store
-	Save(ctx context.Context, aggregateID string, records ...Record) error
+	// Save the provided serialized records to the store.
+	// expectedVersion is the version of the stream the records were built upon; 0 for a new stream.
+	// Records must continue the stream from expectedVersion+1 without gaps.
+	// When the stream is at any other version, an *ErrConcurrencyConflict is returned and nothing is written.
+	Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error
 
 	// Load the history of events up to the version specified.
 	// When toVersion is 0, all events will be loaded.
 	// To start at the beginning, fromVersion should be set to 0
 	Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (History, error)
 }
+
+// GlobalReader is implemented by stores that keep a global log of every record across all aggregates
+type GlobalReader interface {
+	// ReadAll returns up to limit records in commit order, starting at fromPosition.
+	// Positions start at 1 and increase with every commit, though records that were rolled back leave gaps.
+	// When limit is 0, the rest of the log is read.
+	ReadAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error)
+}
+
+// Append is a batch of records for one stream, as saved by a MultiStreamSaver
+type Append struct {
+	// AggregateID contains the id of the stream to append to
+	AggregateID string
+
+	// ExpectedVersion contains the version of the stream the records were built upon; 0 for a new stream
+	ExpectedVersion int
+
+	// Records contains the records to append, continuing the stream from ExpectedVersion+1 without gaps
+	Records []Record
+}
+
+// MultiStreamSaver is implemented by stores that can append to several streams atomically
+type MultiStreamSaver interface {
+	// SaveAll saves the records of every stream in a single transaction. When any stream is not at its
+	// expected version, nothing is written and an *ErrConcurrencyConflict names that stream.
+	SaveAll(ctx context.Context, appends ...Append) error
+}
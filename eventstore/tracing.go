@@ -0,0 +1,174 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer that traces stores and repositories
+const TracerName = "github.com/cannahum/eventsourcing-lite"
+
+// Attributes of the spans of a traced store, which the Repository's spans share
+const (
+	AggregateIDKey      = attribute.Key("eventsourcing.aggregate_id")
+	EventCountKey       = attribute.Key("eventsourcing.event_count")
+	EventTypesKey       = attribute.Key("eventsourcing.event_types")
+	BackendKey          = attribute.Key("eventsourcing.store.backend")
+	ConsumedCapacityKey = attribute.Key("eventsourcing.store.consumed_capacity")
+)
+
+// Backend names the kind of store, as the spans of a traced store report it
+func Backend(store EventStore) string {
+	switch s := store.(type) {
+	case storeWrapper:
+		return Backend(s.wrapped())
+	case *memoryEventStore:
+		return "memory"
+	case *DynamoDBStore:
+		return "dynamodb"
+	case *SQLStore:
+		return s.dialect.name
+	case *FileStore:
+		return "file"
+	}
+	return fmt.Sprintf("%T", store)
+}
+
+// tracingStore traces the calls to another store
+type tracingStore struct {
+	store   EventStore
+	tracer  trace.Tracer
+	backend string
+}
+
+// GetTracingStore wraps a store so that every call makes an OpenTelemetry span, created with tp or with the
+// global TracerProvider when tp is nil. A DynamoDBStore also reports the capacity each call consumed.
+// The wrapper implements GlobalReader and MultiStreamSaver when store does.
+func GetTracingStore(store EventStore, tp trace.TracerProvider) EventStore {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return wrap(&tracingStore{
+		store:   store,
+		tracer:  tp.Tracer(TracerName),
+		backend: Backend(store),
+	})
+}
+
+func (t *tracingStore) wrapped() EventStore {
+	return t.store
+}
+
+func (t *tracingStore) Save(ctx context.Context, aggregateID string, expectedVersion int, records ...Record) error {
+	ctx, span := t.start(ctx, "EventStore.Save",
+		AggregateIDKey.String(aggregateID),
+		EventCountKey.Int(len(records)),
+		EventTypesKey.StringSlice(eventTypes(records)),
+	)
+	err := t.store.Save(ctx, aggregateID, expectedVersion, records...)
+	t.end(ctx, span, err)
+	return err
+}
+
+func (t *tracingStore) Load(ctx context.Context, aggregateID string, fromVersion, toVersion int) (History, error) {
+	ctx, span := t.start(ctx, "EventStore.Load", AggregateIDKey.String(aggregateID))
+	history, err := t.store.Load(ctx, aggregateID, fromVersion, toVersion)
+	span.SetAttributes(EventCountKey.Int(len(history)))
+	if errors.Is(err, ErrAggregateNotFound) {
+		// Loading an aggregate that does not exist yet is how a new one starts, not a failure
+		t.end(ctx, span, nil)
+	} else {
+		t.end(ctx, span, err)
+	}
+	return history, err
+}
+
+func (t *tracingStore) saveAll(ctx context.Context, appends ...Append) error {
+	var aggregateIDs []string
+	var records []Record
+	for _, a := range appends {
+		aggregateIDs = append(aggregateIDs, a.AggregateID)
+		records = append(records, a.Records...)
+	}
+	ctx, span := t.start(ctx, "EventStore.SaveAll",
+		AggregateIDKey.StringSlice(aggregateIDs),
+		EventCountKey.Int(len(records)),
+		EventTypesKey.StringSlice(eventTypes(records)),
+	)
+
+	err := t.store.(MultiStreamSaver).SaveAll(ctx, appends...)
+	t.end(ctx, span, err)
+	return err
+}
+
+func (t *tracingStore) readAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	ctx, span := t.start(ctx, "EventStore.ReadAll")
+	result, err := t.store.(GlobalReader).ReadAll(ctx, fromPosition, limit)
+	span.SetAttributes(EventCountKey.Int(len(result)))
+	t.end(ctx, span, err)
+	return result, err
+}
+
+// start starts a span and has ctx collect the capacity the store consumes under it
+func (t *tracingStore) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(append(attrs, BackendKey.String(t.backend))...))
+	return context.WithValue(ctx, capacityKey{}, &capacityMeter{}), span
+}
+
+// end records the consumed capacity and the error, if any, and ends the span
+func (t *tracingStore) end(ctx context.Context, span trace.Span, err error) {
+	if meter, ok := ctx.Value(capacityKey{}).(*capacityMeter); ok {
+		meter.mux.Lock()
+		if meter.measured {
+			span.SetAttributes(ConsumedCapacityKey.Float64(meter.units))
+		}
+		meter.mux.Unlock()
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func eventTypes(records []Record) []string {
+	types := make([]string, 0, len(records))
+	for _, record := range records {
+		types = append(types, record.EventType)
+	}
+	return types
+}
+
+type capacityKey struct{}
+
+// capacityMeter adds up the capacity units a store reports consuming
+type capacityMeter struct {
+	mux      sync.Mutex
+	units    float64
+	measured bool
+}
+
+// measuringCapacity tells whether ctx collects the capacity a store consumes
+func measuringCapacity(ctx context.Context) bool {
+	_, ok := ctx.Value(capacityKey{}).(*capacityMeter)
+	return ok
+}
+
+// addCapacity adds capacity units to those ctx collects, if it does
+func addCapacity(ctx context.Context, units float64) {
+	meter, ok := ctx.Value(capacityKey{}).(*capacityMeter)
+	if !ok {
+		return
+	}
+	meter.mux.Lock()
+	defer meter.mux.Unlock()
+	meter.units += units
+	meter.measured = true
+}
@@ -0,0 +1,115 @@
+package eventstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttributes returns the attributes of a span by key
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracingStore(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	s := GetTracingStore(GetLocalStore(), sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	t.Run("test Save makes a span", func(ct *testing.T) {
+		err := s.Save(ctx, "traced", 0,
+			Record{Version: 1, Data: []byte("first"), EventType: "TodoCreated"},
+			Record{Version: 2, Data: []byte("second"), EventType: "TodoDone"},
+		)
+		assert.Nil(ct, err)
+
+		spans := recorder.Ended()
+		assert.Len(ct, spans, 1)
+		assert.Equal(ct, "EventStore.Save", spans[0].Name())
+		attrs := spanAttributes(spans[0])
+		assert.Equal(ct, "traced", attrs[AggregateIDKey].AsString())
+		assert.Equal(ct, int64(2), attrs[EventCountKey].AsInt64())
+		assert.Equal(ct, []string{"TodoCreated", "TodoDone"}, attrs[EventTypesKey].AsStringSlice())
+		assert.Equal(ct, "memory", attrs[BackendKey].AsString())
+		assert.Equal(ct, codes.Unset, spans[0].Status().Code)
+	})
+
+	t.Run("test Load makes a span", func(ct *testing.T) {
+		history, err := s.Load(ctx, "traced", 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, history, 2)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		assert.Equal(ct, "EventStore.Load", span.Name())
+		assert.Equal(ct, int64(2), spanAttributes(span)[EventCountKey].AsInt64())
+	})
+
+	t.Run("test Load of an unknown aggregate does not mark its span", func(ct *testing.T) {
+		_, err := s.Load(ctx, "unknown", 0, 0)
+		assert.NotNil(ct, err)
+
+		spans := recorder.Ended()
+		assert.Equal(ct, codes.Unset, spans[len(spans)-1].Status().Code)
+	})
+
+	t.Run("test a failed Save marks its span (error)", func(ct *testing.T) {
+		err := s.Save(ctx, "traced", 0, Record{Version: 1, Data: []byte("competing")})
+		assert.NotNil(ct, err)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		assert.Equal(ct, codes.Error, span.Status().Code)
+		assert.Len(ct, span.Events(), 1)
+	})
+
+	t.Run("test SaveAll and ReadAll go through to the store", func(ct *testing.T) {
+		saver, ok := s.(MultiStreamSaver)
+		assert.True(ct, ok)
+		err := saver.SaveAll(ctx,
+			Append{AggregateID: "traced", ExpectedVersion: 2, Records: []Record{{Version: 3, EventType: "TodoUndone"}}},
+			Append{AggregateID: "other", ExpectedVersion: 0, Records: []Record{{Version: 1, EventType: "TodoCreated"}}},
+		)
+		assert.Nil(ct, err)
+
+		result, err := s.(GlobalReader).ReadAll(ctx, 0, 0)
+		assert.Nil(ct, err)
+		assert.Len(ct, result, 4)
+
+		spans := recorder.Ended()
+		saveAll := spanAttributes(spans[len(spans)-2])
+		assert.Equal(ct, []string{"traced", "other"}, saveAll[AggregateIDKey].AsStringSlice())
+		assert.Equal(ct, int64(4), spanAttributes(spans[len(spans)-1])[EventCountKey].AsInt64())
+	})
+
+	t.Run("test Backend sees through the wrapper", func(ct *testing.T) {
+		assert.Equal(ct, "memory", Backend(s))
+	})
+
+	t.Run("test the wrapper only implements what the store does", func(ct *testing.T) {
+		local := GetLocalStore()
+		plain := GetTracingStore(struct{ EventStore }{local}, nil)
+		_, ok := plain.(MultiStreamSaver)
+		assert.False(ct, ok)
+		_, ok = plain.(GlobalReader)
+		assert.False(ct, ok)
+
+		reader := GetTracingStore(struct {
+			EventStore
+			GlobalReader
+		}{local, local.(GlobalReader)}, nil)
+		_, ok = reader.(MultiStreamSaver)
+		assert.False(ct, ok)
+		_, ok = reader.(GlobalReader)
+		assert.True(ct, ok)
+	})
+}
@@ -0,0 +1,60 @@
+package eventstore
+
+import "context"
+
+// storeWrapper is a store that wraps another one, like the tracing and metrics stores. Its saveAll and readAll
+// pass SaveAll and ReadAll on to the wrapped store, and wrap only exposes them when that store implements them.
+type storeWrapper interface {
+	EventStore
+	wrapped() EventStore
+	saveAll(ctx context.Context, appends ...Append) error
+	readAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error)
+}
+
+// wrap returns w as a store that implements MultiStreamSaver and GlobalReader if, and only if, the store it
+// wraps does
+func wrap(w storeWrapper) EventStore {
+	_, isSaver := w.wrapped().(MultiStreamSaver)
+	_, isReader := w.wrapped().(GlobalReader)
+	switch {
+	case isSaver && isReader:
+		return multiStreamGlobalWrapper{w}
+	case isSaver:
+		return multiStreamWrapper{w}
+	case isReader:
+		return globalWrapper{w}
+	}
+	return w
+}
+
+type multiStreamWrapper struct {
+	storeWrapper
+}
+
+// SaveAll implements the MultiStreamSaver interface
+func (w multiStreamWrapper) SaveAll(ctx context.Context, appends ...Append) error {
+	return w.saveAll(ctx, appends...)
+}
+
+type globalWrapper struct {
+	storeWrapper
+}
+
+// ReadAll implements the GlobalReader interface
+func (w globalWrapper) ReadAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	return w.readAll(ctx, fromPosition, limit)
+}
+
+type multiStreamGlobalWrapper struct {
+	storeWrapper
+}
+
+// SaveAll implements the MultiStreamSaver interface
+func (w multiStreamGlobalWrapper) SaveAll(ctx context.Context, appends ...Append) error {
+	return w.saveAll(ctx, appends...)
+}
+
+// ReadAll implements the GlobalReader interface
+func (w multiStreamGlobalWrapper) ReadAll(ctx context.Context, fromPosition int64, limit int) ([]GlobalRecord, error) {
+	return w.readAll(ctx, fromPosition, limit)
+}
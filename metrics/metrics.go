@@ -0,0 +1,62 @@
+// Package metrics defines what repositories and stores measure, independently of where the measurements go.
+// See the prometheus subpackage for a Recorder that exports them to Prometheus.
+package metrics
+
+import "time"
+
+// Outcome is how a command ended
+type Outcome string
+
+const (
+	// OutcomeSuccess is a command whose events were saved
+	OutcomeSuccess Outcome = "success"
+	// OutcomeRejected is a command its aggregate refused to handle
+	OutcomeRejected Outcome = "rejected"
+	// OutcomeConflict is a command whose events lost a race with another writer of the aggregate
+	OutcomeConflict Outcome = "conflict"
+	// OutcomeError is a command that failed for any other reason
+	OutcomeError Outcome = "error"
+)
+
+// Recorder receives the measurements of repositories and stores. It must be safe for concurrent use.
+type Recorder interface {
+	// CommandHandled records how long Repository.Apply took for a command, and how it ended
+	CommandHandled(commandType string, outcome Outcome, duration time.Duration)
+
+	// CommandRetried records that a command was handled again after its events lost a race with another writer
+	CommandRetried(commandType string)
+
+	// EventsAppended records that a store saved count events of a type
+	EventsAppended(backend, eventType string, count int)
+
+	// HistoryLoaded records how many events the history of an aggregate had when it was loaded, whether they were
+	// replayed or restored from a snapshot or cache
+	HistoryLoaded(aggregateType string, events int)
+
+	// ObserverFailed records that an observer failed to observe an event
+	ObserverFailed(observer string)
+
+	// ConditionalCheckFailed records a DynamoDB write rejected because one of its conditions failed
+	ConditionalCheckFailed(table string)
+}
+
+// Nop is a Recorder that drops every measurement
+type Nop struct{}
+
+// CommandHandled implements the Recorder interface
+func (Nop) CommandHandled(string, Outcome, time.Duration) {}
+
+// CommandRetried implements the Recorder interface
+func (Nop) CommandRetried(string) {}
+
+// EventsAppended implements the Recorder interface
+func (Nop) EventsAppended(string, string, int) {}
+
+// HistoryLoaded implements the Recorder interface
+func (Nop) HistoryLoaded(string, int) {}
+
+// ObserverFailed implements the Recorder interface
+func (Nop) ObserverFailed(string) {}
+
+// ConditionalCheckFailed implements the Recorder interface
+func (Nop) ConditionalCheckFailed(string) {}
@@ -0,0 +1,109 @@
+// Package prometheus exports the measurements of repositories and stores to Prometheus
+package prometheus
+
+import (
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/metrics"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// HistoryBuckets are the buckets of the histogram of how many events the loaded histories have
+var HistoryBuckets = prom.ExponentialBuckets(1, 4, 8)
+
+// Recorder is a metrics.Recorder that keeps its measurements in Prometheus collectors
+type Recorder struct {
+	commandDuration          *prom.HistogramVec
+	commandRetries           *prom.CounterVec
+	eventsAppended           *prom.CounterVec
+	historyLength            *prom.HistogramVec
+	observerFailures         *prom.CounterVec
+	conditionalCheckFailures *prom.CounterVec
+}
+
+// NewRecorder creates the collectors, named under namespace, and registers them with reg.
+// When one of them cannot be registered, those registered before it are unregistered again.
+func NewRecorder(reg prom.Registerer, namespace string) (*Recorder, error) {
+	r := &Recorder{
+		commandDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "How long applying a command took, by command type and outcome.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"command", "outcome"}),
+		commandRetries: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "command_retries_total",
+			Help:      "Commands handled again after losing a race with another writer, by command type.",
+		}, []string{"command"}),
+		eventsAppended: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_appended_total",
+			Help:      "Events saved to a store, by store backend and event type.",
+		}, []string{"backend", "event_type"}),
+		historyLength: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "history_length_events",
+			Help:      "How many events the history of a loaded aggregate had, by aggregate type.",
+			Buckets:   HistoryBuckets,
+		}, []string{"aggregate"}),
+		observerFailures: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "observer_failures_total",
+			Help:      "Events an observer failed to observe, by observer.",
+		}, []string{"observer"}),
+		conditionalCheckFailures: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "dynamodb_conditional_check_failures_total",
+			Help:      "DynamoDB writes rejected because a condition failed, by table.",
+		}, []string{"table"}),
+	}
+
+	collectors := []prom.Collector{
+		r.commandDuration,
+		r.commandRetries,
+		r.eventsAppended,
+		r.historyLength,
+		r.observerFailures,
+		r.conditionalCheckFailures,
+	}
+	for i, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			for _, registered := range collectors[:i] {
+				reg.Unregister(registered)
+			}
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// CommandHandled implements the metrics.Recorder interface
+func (r *Recorder) CommandHandled(commandType string, outcome metrics.Outcome, duration time.Duration) {
+	r.commandDuration.WithLabelValues(commandType, string(outcome)).Observe(duration.Seconds())
+}
+
+// CommandRetried implements the metrics.Recorder interface
+func (r *Recorder) CommandRetried(commandType string) {
+	r.commandRetries.WithLabelValues(commandType).Inc()
+}
+
+// EventsAppended implements the metrics.Recorder interface
+func (r *Recorder) EventsAppended(backend, eventType string, count int) {
+	r.eventsAppended.WithLabelValues(backend, eventType).Add(float64(count))
+}
+
+// HistoryLoaded implements the metrics.Recorder interface
+func (r *Recorder) HistoryLoaded(aggregateType string, events int) {
+	r.historyLength.WithLabelValues(aggregateType).Observe(float64(events))
+}
+
+// ObserverFailed implements the metrics.Recorder interface
+func (r *Recorder) ObserverFailed(observer string) {
+	r.observerFailures.WithLabelValues(observer).Inc()
+}
+
+// ConditionalCheckFailed implements the metrics.Recorder interface
+func (r *Recorder) ConditionalCheckFailed(table string) {
+	r.conditionalCheckFailures.WithLabelValues(table).Inc()
+}
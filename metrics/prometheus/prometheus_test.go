@@ -0,0 +1,74 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cannahum/eventsourcing-lite/metrics"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	reg := prom.NewRegistry()
+	r, err := NewRecorder(reg, "todo")
+	assert.Nil(t, err)
+
+	t.Run("test measurements reach the collectors", func(ct *testing.T) {
+		r.CommandHandled("CreateTodo", metrics.OutcomeSuccess, 10*time.Millisecond)
+		r.CommandHandled("CreateTodo", metrics.OutcomeConflict, 20*time.Millisecond)
+		r.CommandRetried("CreateTodo")
+		r.EventsAppended("memory", "TodoCreated", 2)
+		r.EventsAppended("memory", "TodoCreated", 1)
+		r.HistoryLoaded("MyTodo", 3)
+		r.ObserverFailed("Notifier")
+		r.ConditionalCheckFailed("todos")
+
+		assert.Equal(ct, 2, testutil.CollectAndCount(r.commandDuration))
+		assert.Equal(ct, 1.0, testutil.ToFloat64(r.commandRetries.WithLabelValues("CreateTodo")))
+		assert.Equal(ct, 3.0, testutil.ToFloat64(r.eventsAppended.WithLabelValues("memory", "TodoCreated")))
+		assert.Equal(ct, 1, testutil.CollectAndCount(r.historyLength))
+		assert.Equal(ct, 1.0, testutil.ToFloat64(r.observerFailures.WithLabelValues("Notifier")))
+		assert.Equal(ct, 1.0, testutil.ToFloat64(r.conditionalCheckFailures.WithLabelValues("todos")))
+	})
+
+	t.Run("test the metrics are registered under the namespace", func(ct *testing.T) {
+		families, err := reg.Gather()
+		assert.Nil(ct, err)
+		var names []string
+		for _, family := range families {
+			names = append(names, family.GetName())
+		}
+		assert.Equal(ct, []string{
+			"todo_command_duration_seconds",
+			"todo_command_retries_total",
+			"todo_dynamodb_conditional_check_failures_total",
+			"todo_events_appended_total",
+			"todo_history_length_events",
+			"todo_observer_failures_total",
+		}, names)
+	})
+
+	t.Run("test registering twice (error)", func(ct *testing.T) {
+		_, err := NewRecorder(reg, "todo")
+		assert.NotNil(ct, err)
+	})
+
+	t.Run("test a failed registration leaves no collector behind", func(ct *testing.T) {
+		reg := prom.NewRegistry()
+		// The collector registered last is taken, so all the others were registered when it fails
+		taken := prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "todo",
+			Name:      "dynamodb_conditional_check_failures_total",
+			Help:      "DynamoDB writes rejected because a condition failed, by table.",
+		}, []string{"table"})
+		assert.Nil(ct, reg.Register(taken))
+		_, err := NewRecorder(reg, "todo")
+		assert.NotNil(ct, err)
+
+		reg.Unregister(taken)
+		_, err = NewRecorder(reg, "todo")
+		assert.Nil(ct, err)
+	})
+}
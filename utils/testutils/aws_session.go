@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -37,7 +38,8 @@ func NewConfig() *AwsConfig {
 func (c *AwsConfig) GetAWSCfg() aws.Config {
 	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		if region == c.Region {
-			if service == dynamodb.ServiceID {
+			// dynamodb-local serves the streams of its tables on the same endpoint
+			if service == dynamodb.ServiceID || service == dynamodbstreams.ServiceID {
 				return aws.Endpoint{
 					PartitionID:       "aws",
 					URL:               c.DynamoDB.Endpoint,
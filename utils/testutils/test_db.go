@@ -68,3 +68,17 @@ func DestroyTestTable(tableName string, db *dynamodb.Client) {
 	}
 	fmt.Println("Deleted test table")
 }
+
+// EnableTestTableStream - Turn on the stream of a test table, with the new image of each item
+func EnableTestTableStream(tableName string, db *dynamodb.Client) {
+	_, err := db.UpdateTable(context.TODO(), &dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: types.StreamViewTypeNewImage,
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Could not enable the stream of the table: %v", err))
+	}
+}